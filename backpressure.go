@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// What a live source does when the TUI falls behind and the events channel
+// is full.
+const (
+	dropNewest = "newest"
+	dropOldest = "oldest"
+	dropBlock  = "block"
+)
+
+var dropPolicies = []string{dropNewest, dropOldest, dropBlock}
+
+// dropNever is used by offline sources (replay, play), which can always wait
+// for the consumer instead of losing data.
+const dropNever = "never"
+
+const (
+	defaultBlockTimeout = 100 * time.Millisecond
+	defaultQueueBudget  = 128 << 20
+	eventBatchSize      = 512
+	// batchFlushInterval bounds how long a partial batch waits for more
+	// events before it is handed over anyway.
+	batchFlushInterval = 20 * time.Millisecond
+)
+
+var eventSize = int(unsafe.Sizeof(StructEvent{}))
+
+// eventSink collects events into batches and delivers them to a channel sized
+// from a memory budget, applying a drop policy when it is full. Except with
+// dropNever it never blocks longer than blockTimeout, so a stalled consumer
+// can only cost events, not stop the reader. add and flush must be called
+// from a single goroutine.
+type eventSink struct {
+	events       chan EventBatch
+	done         <-chan struct{}
+	policy       string
+	blockTimeout time.Duration
+	batch        []StructEvent
+	batchStart   time.Time
+	seek         uint64 // stamped on every batch, advanced by reset
+	dropped      atomic.Uint64
+}
+
+func newEventSink(budget int, done <-chan struct{}, policy string, blockTimeout time.Duration) *eventSink {
+	if policy == "" {
+		policy = dropNewest
+	}
+	if blockTimeout <= 0 {
+		blockTimeout = defaultBlockTimeout
+	}
+	if budget <= 0 {
+		budget = defaultQueueBudget
+	}
+	return &eventSink{
+		events:       make(chan EventBatch, max(1, budget/(eventBatchSize*eventSize))),
+		done:         done,
+		policy:       policy,
+		blockTimeout: blockTimeout,
+		batch:        make([]StructEvent, 0, eventBatchSize),
+	}
+}
+
+func checkDropPolicy(policy string) error {
+	if policy == "" || slices.Contains(dropPolicies, policy) {
+		return nil
+	}
+	return fmt.Errorf("unknown drop policy %q (want %s)", policy, strings.Join(dropPolicies, ", "))
+}
+
+func (q *eventSink) add(ev StructEvent) {
+	if len(q.batch) == 0 {
+		q.batchStart = time.Now()
+	}
+	q.batch = append(q.batch, ev)
+	if len(q.batch) >= eventBatchSize {
+		q.flush()
+	}
+}
+
+// pending reports whether a partial batch is waiting to be flushed.
+func (q *eventSink) pending() bool { return len(q.batch) > 0 }
+
+// flush hands the current batch to the consumer, which then owns it.
+func (q *eventSink) flush() {
+	if len(q.batch) == 0 {
+		return
+	}
+	batch := EventBatch{Events: q.batch, Seek: q.seek}
+	q.batch = make([]StructEvent, 0, eventBatchSize)
+	q.push(batch)
+}
+
+// idle is called by paced sources before sleeping for delay. The batch is
+// flushed if it is already old enough or would otherwise wait too long.
+func (q *eventSink) idle(delay time.Duration) {
+	if delay >= batchFlushInterval || time.Since(q.batchStart) >= batchFlushInterval {
+		q.flush()
+	}
+}
+
+// reset discards the unflushed batch and everything queued, e.g. on seek,
+// and stamps later batches with the next seek number. A batch the consumer
+// took just before the reset keeps the old number.
+func (q *eventSink) reset() {
+	q.batch = q.batch[:0]
+	q.seek++
+	for {
+		select {
+		case <-q.events:
+		default:
+			return
+		}
+	}
+}
+
+func (q *eventSink) push(batch EventBatch) {
+	select {
+	case q.events <- batch:
+		return
+	default:
+	}
+
+	switch q.policy {
+	case dropOldest:
+		// Make room by discarding the oldest queued batch. The consumer may
+		// have drained the channel meanwhile, so only count what we discard.
+		select {
+		case old := <-q.events:
+			q.dropped.Add(uint64(len(old.Events)))
+		default:
+		}
+		select {
+		case q.events <- batch:
+		default:
+			q.dropped.Add(uint64(len(batch.Events)))
+		}
+
+	case dropBlock:
+		timer := time.NewTimer(q.blockTimeout)
+		defer timer.Stop()
+		select {
+		case q.events <- batch:
+		case <-timer.C:
+			q.dropped.Add(uint64(len(batch.Events)))
+		case <-q.done:
+		}
+
+	case dropNever:
+		select {
+		case q.events <- batch:
+		case <-q.done:
+		}
+
+	default:
+		q.dropped.Add(uint64(len(batch.Events)))
+	}
+}
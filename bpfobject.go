@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+var errBTFIncompatible = errors.New("eBPF object is incompatible with the running kernel")
+
+// loadCollectionSpec reads the object from path when given, otherwise the
+// copy embedded at build time.
+func loadCollectionSpec(path string) (*ebpf.CollectionSpec, error) {
+	if path != "" {
+		return ebpf.LoadCollectionSpec(path)
+	}
+	if len(embeddedObject) == 0 {
+		return nil, errors.New("ionet was built without an embedded ioNet.o (go build -tags embedbpf): pass -bpf-object")
+	}
+	return ebpf.LoadCollectionSpecFromReader(bytes.NewReader(embeddedObject))
+}
+
+// explainLoadError turns missing kernel BTF into errNoBTF and leaves other
+// errors untouched.
+func explainLoadError(err error) error {
+	if _, kerr := btf.LoadKernelSpec(); kerr != nil {
+		return fmt.Errorf("%w (%v); a kernel built with CONFIG_DEBUG_INFO_BTF=y is required", errNoBTF, kerr)
+	}
+	return err
+}
+
+// unmatchedRelocation returns the first CO-RE relocation in spec that has no
+// match in the running kernel's BTF. cilium/ebpf loads such relocations as a
+// poisoned instruction, so the program then fails in the verifier.
+func unmatchedRelocation(spec *ebpf.CollectionSpec) *btf.CORERelocation {
+	kernel, err := btf.LoadKernelSpec()
+	if err != nil {
+		return nil
+	}
+	bo := spec.ByteOrder
+	if bo == nil {
+		bo = binary.NativeEndian
+	}
+	for _, prog := range spec.Programs {
+		var relos []*btf.CORERelocation
+		var insns []asm.Instruction
+		for _, ins := range prog.Instructions {
+			if relo := btf.CORERelocationMetadata(&ins); relo != nil {
+				relos = append(relos, relo)
+				insns = append(insns, ins)
+			}
+		}
+		if len(relos) == 0 {
+			continue
+		}
+		b, err := btf.NewBuilder(nil)
+		if err != nil {
+			return nil
+		}
+		fixups, err := btf.CORERelocate(relos, []*btf.Spec{kernel}, bo, b.Add)
+		if err != nil {
+			continue
+		}
+		for i := range fixups {
+			if fixups[i].Apply(&insns[i]) == nil && poisoned(insns[i]) {
+				return relos[i]
+			}
+		}
+	}
+	return nil
+}
+
+func poisoned(ins asm.Instruction) bool {
+	return ins.Constant == btf.COREBadRelocationSentinel && (ins.IsBuiltinCall() || ins.OpCode.IsDWordLoad())
+}
@@ -0,0 +1,12 @@
+//go:build embedbpf
+
+package main
+
+import _ "embed"
+
+// embeddedObject is eBPF_module/ioNet.o, which make must build before
+// go build -tags embedbpf. Without the tag the object is left out, so the
+// tests build without clang.
+//
+//go:embed eBPF_module/ioNet.o
+var embeddedObject []byte
@@ -0,0 +1,6 @@
+//go:build !embedbpf
+
+package main
+
+// embeddedObject is empty: live capture needs -bpf-object.
+var embeddedObject []byte
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cilium/ebpf/asm"
+	"github.com/cilium/ebpf/btf"
+)
+
+func TestPoisoned(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ins  asm.Instruction
+		want bool
+	}{
+		{"poisoned field access", asm.BuiltinFunc(btf.COREBadRelocationSentinel).Call(), true},
+		{"poisoned dword load", asm.LoadImm(asm.R10, btf.COREBadRelocationSentinel, asm.DWord), true},
+		{"helper call", asm.FnMapLookupElem.Call(), false},
+		{"constant load", asm.LoadImm(asm.R1, 42, asm.DWord), false},
+		{"sentinel as plain operand", asm.Mov.Imm(asm.R1, btf.COREBadRelocationSentinel), false},
+	} {
+		if got := poisoned(tt.ins); got != tt.want {
+			t.Errorf("%s: poisoned = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
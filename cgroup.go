@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const mountInfoPath = "/proc/self/mountinfo"
+
+var (
+	attachLabels sync.Map
+	attachIDMu   sync.Mutex
+)
+
+type cgroupTarget struct {
+	Label string
+	Path  string
+}
+
+// cgroupTargets is a flag.Value collecting repeated -cgroup [label=]path flags.
+type cgroupTargets []cgroupTarget
+
+func (t *cgroupTargets) String() string {
+	var parts []string
+	for _, target := range *t {
+		parts = append(parts, target.Label+"="+target.Path)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (t *cgroupTargets) Set(value string) error {
+	label, path, found := strings.Cut(value, "=")
+	if !found {
+		label, path = "", value
+	}
+	if path == "" {
+		return errors.New("empty cgroup path")
+	}
+	*t = append(*t, cgroupTarget{Label: label, Path: path})
+	return nil
+}
+
+// resolveCgroupTargets makes every target absolute under the cgroup2 mount and
+// fills in default labels. No targets means the root of the hierarchy.
+func resolveCgroupTargets(targets []cgroupTarget) ([]cgroupTarget, error) {
+	mount, err := findCgroup2Mount()
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		targets = []cgroupTarget{{Label: "root", Path: mount}}
+	}
+
+	resolved := make([]cgroupTarget, 0, len(targets))
+	for _, target := range targets {
+		path := target.Path
+		if !underMount(path, mount) {
+			path = filepath.Join(mount, path)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("cgroup %s: %w", target.Path, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("cgroup %s: not a directory", target.Path)
+		}
+
+		label := target.Label
+		if label == "" {
+			label = filepath.Base(path)
+			if path == filepath.Clean(mount) {
+				label = "root"
+			}
+		}
+		resolved = append(resolved, cgroupTarget{Label: label, Path: path})
+	}
+	return resolved, nil
+}
+
+// underMount reports whether the absolute path is mount or a directory below
+// it, comparing whole path elements.
+func underMount(path, mount string) bool {
+	rel, err := filepath.Rel(mount, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+func findCgroup2Mount() (string, error) {
+	f, err := os.Open(mountInfoPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// id parent major:minor root mountpoint options [optional...] - fstype source superoptions
+		fields := strings.Fields(scanner.Text())
+		sep := slices.Index(fields, "-")
+		if sep < 5 || sep+1 >= len(fields) {
+			continue
+		}
+		if fields[sep+1] == "cgroup2" {
+			return unescapeMountPath(fields[4]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%w: no cgroup2 entry in %s", errNoCgroup2, mountInfoPath)
+}
+
+func unescapeMountPath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if v, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+func registerAttachLabel(id uint32, label string) {
+	attachLabels.Store(id, label)
+}
+
+// attachIDFor returns the id label is registered under, registering it with
+// the next free id if needed, so labels keep their ids across reloads.
+func attachIDFor(label string) uint32 {
+	attachIDMu.Lock()
+	defer attachIDMu.Unlock()
+	var next uint32
+	for id, known := range attachLabelTable() {
+		if known == label {
+			return id
+		}
+		next = max(next, id+1)
+	}
+	registerAttachLabel(next, label)
+	return next
+}
+
+func getAttachLabel(id uint32) string {
+	if label, ok := attachLabels.Load(id); ok {
+		return label.(string)
+	}
+	return "-"
+}
+
+func attachLabelTable() map[uint32]string {
+	table := make(map[uint32]string)
+	attachLabels.Range(func(k, v any) bool {
+		table[k.(uint32)] = v.(string)
+		return true
+	})
+	return table
+}
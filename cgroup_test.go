@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestUnderMount(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want bool
+	}{
+		{"/sys/fs/cgroup", true},
+		{"/sys/fs/cgroup/", true},
+		{"/sys/fs/cgroup/system.slice", true},
+		{"/sys/fs/cgroup/system.slice/sshd.service", true},
+		{"/sys/fs/cgroup2", false},
+		{"/sys/fs/cgroup-v2/user.slice", false},
+		{"/sys/fs", false},
+		{"system.slice", false},
+	} {
+		if got := underMount(tt.path, "/sys/fs/cgroup"); got != tt.want {
+			t.Errorf("underMount(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const clockResyncInterval = time.Second
+
+// monoClock converts bpf_ktime_get_ns (CLOCK_MONOTONIC) readings to wall
+// time. The offset is refreshed periodically so NTP adjustments of the
+// realtime clock are picked up.
+type monoClock struct {
+	mu     sync.Mutex
+	offset int64
+	synced time.Time
+}
+
+// wall returns mono as unix nanoseconds, or the current time when the event
+// carries no kernel timestamp (flow records, older objects).
+func (c *monoClock) wall(mono uint64) uint64 {
+	now := time.Now()
+	if mono == 0 {
+		return uint64(now.UnixNano())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.synced) > clockResyncInterval {
+		var m, r unix.Timespec
+		if unix.ClockGettime(unix.CLOCK_MONOTONIC, &m) == nil && unix.ClockGettime(unix.CLOCK_REALTIME, &r) == nil {
+			c.offset = r.Nano() - m.Nano()
+			c.synced = now
+		}
+	}
+	return uint64(int64(mono) + c.offset)
+}
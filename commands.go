@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type captureConfig struct {
+	bpfObject    string
+	configFile   string
+	attach       string
+	interfaces   string
+	cgroups      cgroupTargets
+	mode         string
+	pollInterval time.Duration
+	ringMB       int
+	sampleRate   uint
+	dropPolicy   string
+	blockTimeout time.Duration
+	queueMB      int
+	enforce      bool
+	limits       limitFlags
+}
+
+// addCaptureFlags registers the capture flags on fs; mode is the default
+// capture mode.
+func addCaptureFlags(fs *flag.FlagSet, mode string) *captureConfig {
+	cfg := &captureConfig{}
+	fs.StringVar(&cfg.bpfObject, "bpf-object", "", "load this eBPF object instead of the one embedded in the binary")
+	fs.StringVar(&cfg.configFile, "config", "", "JSON file with attach, iface, cgroups, ring_mb and sample; re-read on SIGHUP and :reload")
+	fs.StringVar(&cfg.attach, "attach", attachCgroup, "where to attach: cgroup (local sockets), tc (per-device ingress/egress via tcx) or xdp (per-device ingress)")
+	fs.StringVar(&cfg.interfaces, "iface", "", "comma-separated interfaces for -attach tc|xdp")
+	fs.Var(&cfg.cgroups, "cgroup", "cgroup v2 to attach to as [label=]path, relative to the cgroup2 mount; repeatable (default: whole host)")
+	fs.StringVar(&cfg.mode, "mode", mode, "capture mode: flows (in-kernel aggregation) or raw (one event per packet)")
+	fs.DurationVar(&cfg.pollInterval, "poll-interval", defaultPollInterval, "how often flows mode reads the kernel flow table")
+	fs.IntVar(&cfg.ringMB, "ring-mb", defaultRingSize/MB, "raw mode ring buffer size in MiB, rounded up to a power of two")
+	fs.StringVar(&cfg.dropPolicy, "drop-policy", dropNewest, "when the UI falls behind: newest (drop new events), oldest (drop queued events) or block (wait up to -block-timeout, then drop)")
+	fs.DurationVar(&cfg.blockTimeout, "block-timeout", defaultBlockTimeout, "how long -drop-policy block waits for room")
+	fs.IntVar(&cfg.queueMB, "queue-mb", defaultQueueBudget>>20, "memory budget in MiB for events queued between the reader and the UI")
+	fs.UintVar(&cfg.sampleRate, "sample", 1, "keep about 1 in N packets in the kernel; counters are scaled back up")
+	fs.BoolVar(&cfg.enforce, "enforce", false, "drop traffic matching the block and limit rules added from the TUI or -limit")
+	fs.Var(&cfg.limits, "limit", "with -enforce, cap outgoing traffic to addr[:port] (IP or CIDR) at rate bytes/s, as addr[:port]=rate, e.g. 10.0.0.5:443=5MB; repeatable")
+	return cfg
+}
+
+func (cfg *captureConfig) newSource() (*bpfSource, error) {
+	opts := loaderOptions{
+		ObjectPath:   cfg.bpfObject,
+		ConfigPath:   cfg.configFile,
+		Attach:       cfg.attach,
+		Targets:      cfg.cgroups,
+		Interfaces:   splitList(cfg.interfaces),
+		Mode:         cfg.mode,
+		PollInterval: cfg.pollInterval,
+		RingSize:     cfg.ringMB * MB,
+		SampleRate:   uint32(min(cfg.sampleRate, maxSampleRate)),
+		DropPolicy:   cfg.dropPolicy,
+		BlockTimeout: cfg.blockTimeout,
+		QueueBudget:  cfg.queueMB << 20,
+		Enforce:      cfg.enforce,
+		Limits:       cfg.limits,
+	}
+	if opts.ConfigPath != "" {
+		var err error
+		if opts, err = readLiveConfig(opts); err != nil {
+			return nil, err
+		}
+	}
+	return newBpfSource(opts), nil
+}
+
+func liveCommand(args []string) (EventSource, error) {
+	fs := flag.NewFlagSet("ionet", flag.ContinueOnError)
+	cfg := addCaptureFlags(fs, captureModeFlows)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ionet [flags]\n       ionet replay|play|record|doctor [flags] ...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	source, err := cfg.newSource()
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func replayCommand(args []string) (EventSource, error) {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	speed := fs.Float64("speed", 1, "playback speed multiplier, 0 replays as fast as possible")
+	local := fs.String("local", "", "comma-separated IPs/CIDRs of the capturing host, used to derive direction")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ionet replay [flags] file.pcap|file.pcapng")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return nil, errors.New("replay expects exactly one capture file")
+	}
+
+	nets, err := parseNets(*local)
+	if err != nil {
+		return nil, err
+	}
+	return newReplaySource(fs.Arg(0), *speed, nets), nil
+}
+
+func playCommand(args []string) (EventSource, error) {
+	fs := flag.NewFlagSet("play", flag.ContinueOnError)
+	speed := fs.Float64("speed", 1, "playback speed multiplier, 0 plays as fast as possible")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ionet play [flags] file")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return nil, errors.New("play expects exactly one recording")
+	}
+	return newPlaySource(fs.Arg(0), *speed), nil
+}
+
+func recordCommand(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ContinueOnError)
+	output := fs.String("o", "", "output file")
+	// Recordings default to raw mode, whose events carry the kernel's
+	// per-packet timestamps; flows mode only has one per poll.
+	cfg := addCaptureFlags(fs, captureModeRaw)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ionet record -o file [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		fs.Usage()
+		return errors.New("record needs an output file")
+	}
+
+	source, err := cfg.newSource()
+	if err != nil {
+		return err
+	}
+	if err := source.Start(); err != nil {
+		return err
+	}
+	defer source.Stop()
+
+	hdr := localRecordingHeader()
+	hdr.Attachments = attachLabelTable()
+	hdr.Mode = source.opts.Mode
+	rw, err := createRecording(*output, hdr)
+	if err != nil {
+		return err
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var written uint64
+	for {
+		select {
+		case batch, ok := <-source.Events():
+			if !ok {
+				return rw.Close()
+			}
+			for _, ev := range batch.Events {
+				if err := rw.WriteEvent(ev); err != nil {
+					rw.Close()
+					return err
+				}
+			}
+			written += uint64(len(batch.Events))
+		case err := <-source.Errors():
+			// Source errors are transient (a failed read, a lost
+			// attachment); only a failing writer ends the recording.
+			fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		case now := <-ticker.C:
+			stats := source.Stats()
+			if err := rw.WriteStats(now, stats); err != nil {
+				rw.Close()
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "\rrecorded %d events, dropped %d (kernel %d), parse errors %d",
+				written, stats.Dropped, stats.KernelDropped, stats.ParseErrors)
+		case <-sig:
+			if err := errors.Join(rw.WriteStats(time.Now(), source.Stats()), rw.Close()); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "\nrecorded %d events to %s\n", written, *output)
+			return nil
+		}
+	}
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseNets(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range splitList(list) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", item, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+func (m *model) updateConnView() {
+	m.aggEventsCount = len(m.conns.conns)
+
+	type sortableRow struct {
+		key connKey
+		c   *connEntry
+	}
+	rows := make([]sortableRow, 0, len(m.conns.conns))
+	for key, c := range m.conns.conns {
+		if !m.showLocal && isLocalIP(bytesToIP(key.Remote)) {
+			continue
+		}
+		if !m.matchesConnFilter(key, c) {
+			continue
+		}
+		rows = append(rows, sortableRow{key, c})
+	}
+
+	// Open connections first, the busiest on top.
+	sort.Slice(rows, func(i, j int) bool {
+		fi, fj := rows[i].c.finished(), rows[j].c.finished()
+		if fi != fj {
+			return !fi
+		}
+		ti, tj := rows[i].c.BytesIn+rows[i].c.BytesOut, rows[j].c.BytesIn+rows[j].c.BytesOut
+		if ti != tj {
+			return ti > tj
+		}
+		return rows[i].c.First < rows[j].c.First
+	})
+
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		c := row.c
+		dir := "?"
+		if c.SawSyn {
+			dir = "in"
+			if c.Outbound {
+				dir = "out"
+			}
+		}
+		lines = append(lines, fmt.Sprintf(format_conn,
+			fixedWidth(hostPort(row.key.Local, row.key.LocalPort), endpointWidth), coloredSeparator,
+			fixedWidth(hostPort(row.key.Remote, row.key.RemotePort), endpointWidth), coloredSeparator,
+			fixedWidth(dir, connDirWidth), coloredSeparator,
+			connStateStyle(c.State).Render(fixedWidth(c.State, connStateWidth)), coloredSeparator,
+			fixedWidth(formatDuration(time.Duration(c.Last-c.First)), durationWidth), coloredSeparator,
+			RedTextSyle.Render(fixedWidth(estimate(parseBytes(c.BytesIn), c.Estimated), bytesWidth)), coloredSeparator,
+			GreenTextSyle.Render(fixedWidth(estimate(parseBytes(c.BytesOut), c.Estimated), bytesWidth)), coloredSeparator,
+			fixedWidth(sniString(flowHostFor(row.key)), sniWidth),
+		))
+	}
+
+	m.headerView.SetContent(tableHeaderConn)
+	m.viewport.SetContent(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+func hostPort(ip [16]byte, port uint16) string {
+	return net.JoinHostPort(bytesToIP(ip).String(), fmt.Sprint(port))
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Hour:
+		return d.Round(time.Second).String()
+	}
+	return d.Round(time.Minute).String()
+}
+
+func connStateStyle(state string) lipgloss.Style {
+	switch state {
+	case connEstablished:
+		return GreenTextSyle
+	case connRefused, connReset:
+		return RedTextSyle
+	}
+	return lipgloss.NewStyle()
+}
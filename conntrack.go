@@ -0,0 +1,205 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// TCP connection states shown in the conn view.
+const (
+	connSynSent     = "SYN_SENT"
+	connEstablished = "ESTABLISHED"
+	connFin         = "FIN"
+	connReset       = "RST"
+	connRefused     = "REFUSED"
+	connTimedOut    = "TIMED_OUT"
+)
+
+const (
+	// synTimeout is how long a SYN may go unanswered.
+	synTimeout = 30 * time.Second
+	// idleTimeout expires established connections that went quiet without
+	// a FIN or RST, e.g. because the capture missed them.
+	idleTimeout = 5 * time.Minute
+	// connLinger keeps finished connections visible before they are dropped.
+	connLinger = time.Minute
+	maxConns   = 16384
+	// maxOrphanRSTs bounds the RSTs kept for a SYN that may follow them.
+	maxOrphanRSTs = 1024
+)
+
+// connKey is the TCP 5-tuple seen from this host, so both directions of a
+// connection land on the same entry.
+type connKey struct {
+	Local      [16]byte
+	Remote     [16]byte
+	LocalPort  uint16
+	RemotePort uint16
+}
+
+type connEntry struct {
+	State string
+	// Outbound is set when the local side sent the SYN.
+	Outbound bool
+	// SawSyn is false for connections first seen mid-stream.
+	SawSyn    bool
+	First     uint64
+	Last      uint64
+	BytesIn   uint64
+	BytesOut  uint64
+	Estimated bool
+}
+
+func (c *connEntry) finished() bool {
+	switch c.State {
+	case connFin, connReset, connRefused, connTimedOut:
+		return true
+	}
+	return false
+}
+
+// connTracker follows TCP connections through the flags of captured
+// packets. Its clock is the newest event timestamp, so replays expire
+// connections in recording time.
+type connTracker struct {
+	conns map[connKey]*connEntry
+	// orphanRSTs holds RSTs of unknown connections. In flows mode all the
+	// deltas of one poll share its timestamp and come in no particular
+	// order, so the RST answering a SYN can come first.
+	orphanRSTs map[connKey]StructEvent
+	now        uint64
+	swept      uint64
+	refused    uint64
+	resets     uint64
+	timeouts   uint64
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[connKey]*connEntry), orphanRSTs: make(map[connKey]StructEvent)}
+}
+
+func connKeyOf(ev StructEvent) connKey {
+	srcIP, dstIP := getIPsFromEvent(ev)
+	if ev.key.Direction == 'o' {
+		return connKey{ip16ToBytes(srcIP), ip16ToBytes(dstIP), ev.key.Sport, ev.key.Dport}
+	}
+	return connKey{ip16ToBytes(dstIP), ip16ToBytes(srcIP), ev.key.Dport, ev.key.Sport}
+}
+
+func (t *connTracker) observe(ev StructEvent) {
+	if ev.key.Protocol != 6 || ev.key.Flags&eventNoL4 != 0 {
+		return
+	}
+	t.now = max(t.now, ev.Timestamp)
+
+	key := connKeyOf(ev)
+	flags := ev.key.TcpFlags
+	outbound := ev.key.Direction == 'o'
+	c := t.conns[key]
+
+	// A SYN on a finished entry is the port pair being reused.
+	if flags&tcpSYN != 0 && flags&tcpACK == 0 && (c == nil || c.finished()) {
+		c = &connEntry{State: connSynSent, Outbound: outbound, SawSyn: true, First: ev.Timestamp}
+		t.add(key, c)
+		if rst, ok := t.orphanRSTs[key]; ok && rst.Timestamp >= ev.Timestamp {
+			delete(t.orphanRSTs, key)
+			defer t.observe(rst)
+		}
+	}
+	if c == nil {
+		if flags&tcpRST != 0 {
+			if len(t.orphanRSTs) < maxOrphanRSTs {
+				t.orphanRSTs[key] = ev
+			}
+			return
+		}
+		c = &connEntry{State: connEstablished, Outbound: outbound, First: ev.Timestamp}
+		t.add(key, c)
+	}
+
+	scale := uint64(max(ev.key.SampleRate, 1))
+	if outbound {
+		c.BytesOut += ev.val.Bytes * scale
+	} else {
+		c.BytesIn += ev.val.Bytes * scale
+	}
+	c.Estimated = c.Estimated || scale > 1
+	c.Last = ev.Timestamp
+
+	switch {
+	case flags&tcpRST != 0:
+		switch c.State {
+		case connSynSent:
+			c.State = connRefused
+			t.refused++
+		case connEstablished:
+			c.State = connReset
+			t.resets++
+		}
+	case c.finished():
+		// Late segments of a closed connection only add bytes.
+	case flags&tcpFIN != 0:
+		c.State = connFin
+	case flags&(tcpSYN|tcpACK) == tcpSYN|tcpACK:
+		c.State = connEstablished
+	case c.State == connSynSent && flags&tcpSYN == 0:
+		// Data or a bare ACK after our SYN: the SYN-ACK was not captured,
+		// e.g. because of sampling.
+		c.State = connEstablished
+	}
+}
+
+func (t *connTracker) add(key connKey, c *connEntry) {
+	if len(t.conns) >= maxConns {
+		t.expire()
+	}
+	if len(t.conns) >= maxConns {
+		t.evictOldest()
+	}
+	t.conns[key] = c
+}
+
+// expire times out silent connections and drops finished ones after
+// connLinger. It is cheap to call often: it scans at most once a second of
+// tracker time.
+func (t *connTracker) expire() {
+	if t.now-t.swept < uint64(time.Second) {
+		return
+	}
+	t.swept = t.now
+
+	for key, rst := range t.orphanRSTs {
+		if rst.Timestamp < t.now {
+			delete(t.orphanRSTs, key)
+		}
+	}
+	for key, c := range t.conns {
+		idle := time.Duration(t.now - c.Last)
+		switch {
+		case c.finished():
+			if idle > connLinger {
+				delete(t.conns, key)
+			}
+		case c.State == connSynSent && idle > synTimeout,
+			c.State != connSynSent && idle > idleTimeout:
+			c.State = connTimedOut
+			t.timeouts++
+		}
+	}
+}
+
+// evictOldest makes room by dropping the least recently seen tenth of the
+// table.
+func (t *connTracker) evictOldest() {
+	lasts := make([]uint64, 0, len(t.conns))
+	for _, c := range t.conns {
+		lasts = append(lasts, c.Last)
+	}
+	slices.Sort(lasts)
+	cutoff := lasts[len(lasts)/10]
+	for key, c := range t.conns {
+		if c.Last <= cutoff {
+			delete(t.conns, key)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// segment is one TCP packet of the connection 10.0.0.2:40000 <-> 10.1.1.1:443.
+type segment struct {
+	dir   byte
+	flags uint8
+	bytes uint64
+}
+
+func TestConnTrackerObserve(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		segments []segment
+		state    string
+		outbound bool
+		sawSyn   bool
+		in, out  uint64
+		refused  uint64
+		resets   uint64
+	}{
+		{"handshake", []segment{
+			{'o', tcpSYN, 60}, {'i', tcpSYN | tcpACK, 60}, {'o', tcpACK, 52},
+		}, connEstablished, true, true, 60, 112, 0, 0},
+		{"inbound handshake", []segment{
+			{'i', tcpSYN, 60}, {'o', tcpSYN | tcpACK, 60},
+		}, connEstablished, false, true, 60, 60, 0, 0},
+		{"syn only", []segment{{'o', tcpSYN, 60}}, connSynSent, true, true, 0, 60, 0, 0},
+		{"missed syn-ack", []segment{
+			{'o', tcpSYN, 60}, {'o', tcpACK | tcpPSH, 500},
+		}, connEstablished, true, true, 0, 560, 0, 0},
+		{"refused", []segment{
+			{'o', tcpSYN, 60}, {'i', tcpRST | tcpACK, 40},
+		}, connRefused, true, true, 40, 60, 1, 0},
+		{"reset", []segment{
+			{'o', tcpSYN, 60}, {'i', tcpSYN | tcpACK, 60}, {'i', tcpRST, 40},
+		}, connReset, true, true, 100, 60, 0, 1},
+		{"fin", []segment{
+			{'o', tcpSYN, 60}, {'i', tcpSYN | tcpACK, 60}, {'o', tcpFIN | tcpACK, 52},
+		}, connFin, true, true, 60, 112, 0, 0},
+		{"late segments after fin", []segment{
+			{'o', tcpSYN, 60}, {'o', tcpFIN | tcpACK, 52}, {'i', tcpSYN | tcpACK, 60},
+		}, connFin, true, true, 60, 112, 0, 0},
+		{"mid-stream", []segment{{'i', tcpACK, 1500}}, connEstablished, false, false, 1500, 0, 0, 0},
+		{"port reuse", []segment{
+			{'o', tcpSYN, 60}, {'i', tcpRST | tcpACK, 40}, {'o', tcpSYN, 60},
+		}, connSynSent, true, true, 0, 60, 1, 0},
+	} {
+		ct := newConnTracker()
+		for i, s := range tt.segments {
+			ev := event4(s.dir, 6, "10.0.0.2", "10.1.1.1", 40000, 443, s.bytes)
+			ev.key.TcpFlags = s.flags
+			ev.Timestamp = uint64(i+1) * uint64(time.Millisecond)
+			ct.observe(ev)
+		}
+		if len(ct.conns) != 1 {
+			t.Errorf("%s: %d connections, want 1", tt.name, len(ct.conns))
+			continue
+		}
+		for _, c := range ct.conns {
+			if c.State != tt.state || c.Outbound != tt.outbound || c.SawSyn != tt.sawSyn ||
+				c.BytesIn != tt.in || c.BytesOut != tt.out {
+				t.Errorf("%s: %+v, want %s outbound=%v sawSyn=%v in=%d out=%d",
+					tt.name, *c, tt.state, tt.outbound, tt.sawSyn, tt.in, tt.out)
+			}
+		}
+		if ct.refused != tt.refused || ct.resets != tt.resets {
+			t.Errorf("%s: refused %d resets %d, want %d %d", tt.name, ct.refused, ct.resets, tt.refused, tt.resets)
+		}
+	}
+}
+
+// In flows mode the deltas of one poll share a timestamp and may come in any
+// order, so the RST answering a SYN can be observed first.
+func TestConnTrackerRSTBeforeSYN(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		rstAt  time.Duration
+		state  string
+		orphan bool
+	}{
+		{"same poll", time.Second, connRefused, false},
+		{"earlier stray rst", time.Second - time.Millisecond, connSynSent, true},
+	} {
+		ct := newConnTracker()
+		rst := event4('i', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 40)
+		rst.key.TcpFlags = tcpRST | tcpACK
+		rst.Timestamp = uint64(tt.rstAt)
+		ct.observe(rst)
+		syn := event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 60)
+		syn.key.TcpFlags = tcpSYN
+		syn.Timestamp = uint64(time.Second)
+		ct.observe(syn)
+
+		c := ct.conns[connKeyOf(syn)]
+		if c == nil || c.State != tt.state {
+			t.Errorf("%s: %+v, want %s", tt.name, c, tt.state)
+		}
+		if tt.state == connRefused && (ct.refused != 1 || c.BytesIn != 40 || c.BytesOut != 60) {
+			t.Errorf("%s: refused %d, %+v; want 1 refused with 40 bytes in and 60 out", tt.name, ct.refused, c)
+		}
+		if _, ok := ct.orphanRSTs[connKeyOf(syn)]; ok != tt.orphan {
+			t.Errorf("%s: orphan RST kept = %v, want %v", tt.name, ok, tt.orphan)
+		}
+
+		// The stray RST is forgotten once the tracker moves on.
+		ct.now = uint64(2 * time.Second)
+		ct.expire()
+		if len(ct.orphanRSTs) != 0 {
+			t.Errorf("%s: %d orphan RSTs kept past their poll", tt.name, len(ct.orphanRSTs))
+		}
+	}
+}
+
+func TestConnTrackerIgnores(t *testing.T) {
+	ct := newConnTracker()
+	ct.observe(event4('o', 17, "10.0.0.2", "10.2.2.2", 5353, 53, 40))
+
+	noL4 := event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 1500)
+	noL4.key.Flags = eventNoL4
+	ct.observe(noL4)
+
+	rst := event4('i', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 40)
+	rst.key.TcpFlags = tcpRST
+	ct.observe(rst)
+
+	if len(ct.conns) != 0 {
+		t.Errorf("tracked %d connections from UDP, non-first fragments and a stray RST", len(ct.conns))
+	}
+}
+
+func TestConnTrackerScalesSampledBytes(t *testing.T) {
+	ct := newConnTracker()
+	ev := event4('i', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 1000)
+	ev.key.TcpFlags = tcpACK
+	ev.key.SampleRate = 8
+	ct.observe(ev)
+	for _, c := range ct.conns {
+		if c.BytesIn != 8000 || !c.Estimated {
+			t.Errorf("%+v, want 8000 bytes in, estimated", *c)
+		}
+	}
+}
+
+func TestConnTrackerExpire(t *testing.T) {
+	ct := newConnTracker()
+	at := func(d time.Duration, dir byte, remotePort uint16, flags uint8) {
+		ev := event4(dir, 6, "10.0.0.2", "10.1.1.1", 40000, remotePort, 60)
+		ev.key.TcpFlags = flags
+		ev.Timestamp = uint64(d)
+		ct.observe(ev)
+	}
+	at(time.Second, 'o', 1, tcpSYN)
+	at(time.Second, 'o', 2, tcpSYN)
+	at(2*time.Second, 'i', 2, tcpRST)
+	at(synTimeout+2*time.Minute, 'o', 3, tcpSYN)
+	ct.expire()
+
+	if c := ct.conns[connKeyOf(event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 1, 0))]; c == nil || c.State != connTimedOut {
+		t.Errorf("unanswered SYN = %+v, want %s", c, connTimedOut)
+	}
+	if c := ct.conns[connKeyOf(event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 2, 0))]; c != nil {
+		t.Errorf("refused connection kept past connLinger: %+v", c)
+	}
+	if ct.timeouts != 1 {
+		t.Errorf("timeouts = %d, want 1", ct.timeouts)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+)
+
+// Byte offsets of the fields of the packed struct traffic_event_t. The
+// decoder reads them directly instead of going through binary.Read, which
+// costs reflection and allocations per packet.
+const (
+	offProtocol   = 0
+	offDirection  = 1
+	offSaddr      = 2
+	offDaddr      = 6
+	offSaddrV6    = 10
+	offDaddrV6    = 26
+	offSport      = 42
+	offDport      = 44
+	offIfindex    = 46
+	offFamily     = 50
+	offPkttype    = 54
+	offBytes      = 58
+	offAttachID   = 66
+	offUid        = 70
+	offCgroupID   = 74
+	offCookie     = 82
+	offPid        = 90
+	offComm       = 94
+	offSampleRate = 110
+	offTsNs       = 114
+	offFlags      = 122
+	offTcpFlags   = 123
+	offSeq        = 124
+	offAck        = 128
+	offWindow     = 132
+
+	rawEventWireSize = 134
+)
+
+// The offsets above must be kept in step with RawEvent; binary.Size is the
+// reflection-based reference layout.
+func init() {
+	if rawEventSize != rawEventWireSize {
+		panic(fmt.Sprintf("RawEvent is %d bytes but the decoder expects %d", rawEventSize, rawEventWireSize))
+	}
+}
+
+// decodeRawEvent fills e from a little-endian traffic_event_t.
+func decodeRawEvent(b []byte, e *RawEvent) error {
+	if len(b) < rawEventWireSize {
+		return fmt.Errorf("short event: %d bytes, want %d", len(b), rawEventWireSize)
+	}
+	le := binary.LittleEndian
+	e.Protocol = b[offProtocol]
+	e.Direction = b[offDirection]
+	e.Saddr = le.Uint32(b[offSaddr:])
+	e.Daddr = le.Uint32(b[offDaddr:])
+	copy(e.SaddrV6[:], b[offSaddrV6:])
+	copy(e.DaddrV6[:], b[offDaddrV6:])
+	e.Sport = le.Uint16(b[offSport:])
+	e.Dport = le.Uint16(b[offDport:])
+	e.Ifindex = le.Uint32(b[offIfindex:])
+	e.Family = le.Uint32(b[offFamily:])
+	e.Pkttype = le.Uint32(b[offPkttype:])
+	e.Bytes = le.Uint64(b[offBytes:])
+	e.AttachID = le.Uint32(b[offAttachID:])
+	e.Uid = le.Uint32(b[offUid:])
+	e.CgroupID = le.Uint64(b[offCgroupID:])
+	e.Cookie = le.Uint64(b[offCookie:])
+	e.Pid = le.Uint32(b[offPid:])
+	copy(e.Comm[:], b[offComm:])
+	e.SampleRate = le.Uint32(b[offSampleRate:])
+	e.TsNs = le.Uint64(b[offTsNs:])
+	e.Flags = b[offFlags]
+	e.TcpFlags = b[offTcpFlags]
+	e.Seq = le.Uint32(b[offSeq:])
+	e.Ack = le.Uint32(b[offAck:])
+	e.Window = le.Uint16(b[offWindow:])
+	return nil
+}
+
+// appendRawEvent is the inverse of decodeRawEvent.
+func appendRawEvent(b []byte, e *RawEvent) []byte {
+	start := len(b)
+	b = append(b, make([]byte, rawEventWireSize)...)
+	out := b[start:]
+	le := binary.LittleEndian
+	out[offProtocol] = e.Protocol
+	out[offDirection] = e.Direction
+	le.PutUint32(out[offSaddr:], e.Saddr)
+	le.PutUint32(out[offDaddr:], e.Daddr)
+	copy(out[offSaddrV6:], e.SaddrV6[:])
+	copy(out[offDaddrV6:], e.DaddrV6[:])
+	le.PutUint16(out[offSport:], e.Sport)
+	le.PutUint16(out[offDport:], e.Dport)
+	le.PutUint32(out[offIfindex:], e.Ifindex)
+	le.PutUint32(out[offFamily:], e.Family)
+	le.PutUint32(out[offPkttype:], e.Pkttype)
+	le.PutUint64(out[offBytes:], e.Bytes)
+	le.PutUint32(out[offAttachID:], e.AttachID)
+	le.PutUint32(out[offUid:], e.Uid)
+	le.PutUint64(out[offCgroupID:], e.CgroupID)
+	le.PutUint64(out[offCookie:], e.Cookie)
+	le.PutUint32(out[offPid:], e.Pid)
+	copy(out[offComm:], e.Comm[:])
+	le.PutUint32(out[offSampleRate:], e.SampleRate)
+	le.PutUint64(out[offTsNs:], e.TsNs)
+	out[offFlags] = e.Flags
+	out[offTcpFlags] = e.TcpFlags
+	le.PutUint32(out[offSeq:], e.Seq)
+	le.PutUint32(out[offAck:], e.Ack)
+	le.PutUint16(out[offWindow:], e.Window)
+	return b
+}
+
+// eventLayout is the C layout a decoder assumes: the member names of the
+// packed struct in declaration order, with their byte offsets.
+type eventLayout struct {
+	name    string
+	size    uint32
+	members []memberOffset
+}
+
+type memberOffset struct {
+	name   string
+	offset uint32
+}
+
+// trafficEventLayout is also stored in recordings, which are decoded through
+// it when the layout has changed since.
+var trafficEventLayout = eventLayout{"traffic_event_t", rawEventWireSize, []memberOffset{
+	{"protocol", offProtocol},
+	{"direction", offDirection},
+	{"saddr", offSaddr},
+	{"daddr", offDaddr},
+	{"saddr_v6", offSaddrV6},
+	{"daddr_v6", offDaddrV6},
+	{"sport", offSport},
+	{"dport", offDport},
+	{"ifindex", offIfindex},
+	{"family", offFamily},
+	{"pkttype", offPkttype},
+	{"bytes", offBytes},
+	{"attach_id", offAttachID},
+	{"uid", offUid},
+	{"cgroup_id", offCgroupID},
+	{"cookie", offCookie},
+	{"pid", offPid},
+	{"comm", offComm},
+	{"sample_rate", offSampleRate},
+	{"ts_ns", offTsNs},
+	{"flags", offFlags},
+	{"tcp_flags", offTcpFlags},
+	{"seq", offSeq},
+	{"ack", offAck},
+	{"window", offWindow},
+}}
+
+var eventLayouts = []eventLayout{
+	trafficEventLayout,
+	{"sock_event_t", sockEventWireSize, []memberOffset{
+		{"kind", offSockKind},
+		{"protocol", offSockProtocol},
+		{"port", offSockPort},
+		{"family", offSockFamily},
+		{"addr", offSockAddr},
+		{"pid", offSockPid},
+		{"uid", offSockUid},
+		{"cgroup_id", offSockCgroupID},
+		{"cookie", offSockCookie},
+		{"ts_ns", offSockTsNs},
+		{"attach_id", offSockAttachID},
+		{"comm", offSockComm},
+	}},
+}
+
+// checkEventLayout compares the decoders against the record types the
+// object was compiled with, so a stale ioNet.o fails loudly at startup
+// instead of producing garbage rows.
+func checkEventLayout(spec *ebpf.CollectionSpec) error {
+	for _, want := range eventLayouts {
+		var event *btf.Struct
+		if err := spec.Types.TypeByName(want.name, &event); err != nil {
+			return fmt.Errorf("eBPF object has no %s type: %w", want.name, err)
+		}
+		if err := want.check(event); err != nil {
+			return fmt.Errorf("%w; rebuild ioNet.o and ionet from the same tree", err)
+		}
+	}
+	return nil
+}
+
+func (l eventLayout) check(event *btf.Struct) error {
+	if event.Size != l.size {
+		return fmt.Errorf("eBPF object's %s is %d bytes, ionet expects %d", l.name, event.Size, l.size)
+	}
+	if len(event.Members) != len(l.members) {
+		return fmt.Errorf("eBPF object's %s has %d fields, ionet expects %d", l.name, len(event.Members), len(l.members))
+	}
+	for i, m := range event.Members {
+		want := l.members[i]
+		if m.Name != want.name || m.Offset.Bytes() != want.offset {
+			return fmt.Errorf("eBPF object's %s has %s at byte %d, ionet expects %s at byte %d",
+				l.name, m.Name, m.Offset.Bytes(), want.name, want.offset)
+		}
+	}
+	return nil
+}
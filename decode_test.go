@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/cilium/ebpf/btf"
+)
+
+var sampleRawEvent = RawEvent{
+	Protocol: 6, Direction: 'i', Saddr: 0x0100000a, Daddr: 0x0200000a,
+	Sport: 443, Dport: 51234, Ifindex: 2, Family: 2, Bytes: 1500,
+	Pid: 1234, Comm: [16]byte{'c', 'u', 'r', 'l'}, SampleRate: 1, TsNs: 1,
+}
+
+func TestDecodeRawEventRoundTrip(t *testing.T) {
+	v6 := RawEvent{
+		Protocol: 17, Direction: 'o', Family: 10, Pkttype: 4, Bytes: 1 << 40,
+		AttachID: 7, Uid: 1000, CgroupID: 1 << 50, Cookie: 99, Pid: 1 << 31,
+		SampleRate: 64, TsNs: 1 << 62, Flags: 3, TcpFlags: tcpSYN | tcpACK,
+		Seq: 0xdeadbeef, Ack: 0xfeedface, Window: 0xffff,
+	}
+	v6.SaddrV6[0], v6.SaddrV6[15] = 0x20, 1
+	v6.DaddrV6[0], v6.DaddrV6[15] = 0xfe, 2
+	copy(v6.Comm[:], "a-very-long-name")
+
+	for _, tt := range []struct {
+		name string
+		ev   RawEvent
+	}{
+		{"zero", RawEvent{}},
+		{"ipv4", sampleRawEvent},
+		{"ipv6 with every field set", v6},
+	} {
+		b := appendRawEvent(nil, &tt.ev)
+		if len(b) != rawEventWireSize {
+			t.Errorf("%s: encoded %d bytes, want %d", tt.name, len(b), rawEventWireSize)
+			continue
+		}
+		var ref bytes.Buffer
+		binary.Write(&ref, binary.LittleEndian, &tt.ev)
+		if !bytes.Equal(b, ref.Bytes()) {
+			t.Errorf("%s: appendRawEvent disagrees with binary.Write\n got %x\nwant %x", tt.name, b, ref.Bytes())
+		}
+		var got RawEvent
+		if err := decodeRawEvent(b, &got); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.ev {
+			t.Errorf("%s: decoded %+v, want %+v", tt.name, got, tt.ev)
+		}
+	}
+}
+
+func TestDecodeRawEventShort(t *testing.T) {
+	var ev RawEvent
+	if err := decodeRawEvent(make([]byte, rawEventWireSize-1), &ev); err == nil {
+		t.Error("short record decoded without error")
+	}
+}
+
+// layoutStruct builds the BTF struct a matching object would carry.
+func layoutStruct(l eventLayout) *btf.Struct {
+	s := &btf.Struct{Name: l.name, Size: l.size}
+	for _, m := range l.members {
+		s.Members = append(s.Members, btf.Member{Name: m.name, Offset: btf.Bits(m.offset * 8)})
+	}
+	return s
+}
+
+func TestEventLayoutCheck(t *testing.T) {
+	for _, l := range eventLayouts {
+		if err := l.check(layoutStruct(l)); err != nil {
+			t.Errorf("%s: matching layout rejected: %v", l.name, err)
+		}
+
+		swapped := layoutStruct(l)
+		swapped.Members[1].Offset, swapped.Members[2].Offset = swapped.Members[2].Offset, swapped.Members[1].Offset
+		if err := l.check(swapped); err == nil {
+			t.Errorf("%s: reordered fields accepted", l.name)
+		}
+
+		renamed := layoutStruct(l)
+		renamed.Members[0].Name = "other"
+		if err := l.check(renamed); err == nil {
+			t.Errorf("%s: renamed field accepted", l.name)
+		}
+
+		extra := layoutStruct(l)
+		extra.Members = append(extra.Members, btf.Member{Name: "pad", Offset: btf.Bits(l.size * 8)})
+		if err := l.check(extra); err == nil {
+			t.Errorf("%s: extra field accepted", l.name)
+		}
+	}
+}
+
+// The traffic_event_t offsets must also match RawEvent, which recordings and
+// binary.Read go through.
+func TestEventLayoutMatchesRawEvent(t *testing.T) {
+	typ := reflect.TypeOf(RawEvent{})
+	members := trafficEventLayout.members
+	if typ.NumField() != len(members) {
+		t.Fatalf("RawEvent has %d fields, traffic_event_t layout %d", typ.NumField(), len(members))
+	}
+	var off uint32
+	for i := 0; i < typ.NumField(); i++ {
+		if members[i].offset != off {
+			t.Errorf("%s at byte %d, %s at byte %d", members[i].name, members[i].offset, typ.Field(i).Name, off)
+		}
+		off += uint32(binary.Size(reflect.Zero(typ.Field(i).Type).Interface()))
+	}
+}
+
+func BenchmarkDecodeRawEvent(b *testing.B) {
+	record := appendRawEvent(nil, &sampleRawEvent)
+	b.ReportAllocs()
+	var ev RawEvent
+	for i := 0; i < b.N; i++ {
+		if err := decodeRawEvent(record, &ev); err != nil {
+			b.Fatal(err)
+		}
+		_ = ev.structEvent(ev.TsNs)
+	}
+}
+
+func BenchmarkBinaryRead(b *testing.B) {
+	record := appendRawEvent(nil, &sampleRawEvent)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var ev RawEvent
+		if err := binary.Read(bytes.NewBuffer(record), binary.LittleEndian, &ev); err != nil {
+			b.Fatal(err)
+		}
+		_ = ev.structEvent(ev.TsNs)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+const (
+	bpfMapDenyV4     = "deny_v4"
+	bpfMapDenyV6     = "deny_v6"
+	bpfMapLimitV4    = "limit_v4"
+	bpfMapLimitV6    = "limit_v6"
+	bpfMapLimitState = "limit_state"
+	bpfMapDenyStats  = "deny_stats"
+	bpfVarEnforce    = "enforce"
+)
+
+// maxDenyRules is MAX_DENY_RULES in ioNet.c.
+const maxDenyRules = 1024
+
+// maxLimitRate keeps rate times a second of nanoseconds within 64 bits in
+// over_limit.
+const maxLimitRate = 16 * GB
+
+// minLimitBurst lets a full GSO packet through however low the rate.
+const minLimitBurst = 64 * KB
+
+// denyKeyV4 and denyKeyV6 mirror the deny trie keys in ioNet.c: the port,
+// matched in full, then the address prefix.
+type denyKeyV4 struct {
+	Prefixlen uint32
+	Port      uint16
+	Addr      [4]byte
+	_         [2]byte
+}
+
+type denyKeyV6 struct {
+	Prefixlen uint32
+	Port      uint16
+	Addr      [16]byte
+	_         [2]byte
+}
+
+// denyCounters mirrors struct deny_stats_t: what a rule dropped, and for a
+// limit rule the bytes it let through.
+type denyCounters struct {
+	Packets uint64
+	Bytes   uint64
+	Sent    uint64
+}
+
+func (a denyCounters) add(b denyCounters) denyCounters {
+	return denyCounters{Packets: a.Packets + b.Packets, Bytes: a.Bytes + b.Bytes, Sent: a.Sent + b.Sent}
+}
+
+// limitState mirrors struct limit_state_t.
+type limitState struct {
+	Lock   uint32 // struct bpf_spin_lock
+	_      uint32
+	Rate   uint64
+	Burst  uint64
+	Tokens uint64
+	LastNs uint64
+}
+
+// denyRule acts on the traffic of a remote network, on one remote port or,
+// with Port 0, on all of them. With a Limit, outgoing traffic beyond Limit
+// bytes per second is dropped; without one, all traffic is.
+type denyRule struct {
+	ID      uint32
+	Net     *net.IPNet
+	Port    uint16
+	Limit   uint64
+	Created time.Time
+	Expires time.Time // zero for rules that stay until removed
+}
+
+func (r denyRule) kind() string {
+	if r.Limit > 0 {
+		return "limit"
+	}
+	return "block"
+}
+
+// target is the rule's network, or just the address for a single host.
+func (r denyRule) target() string {
+	if ones, bits := r.Net.Mask.Size(); ones == bits {
+		return r.Net.IP.String()
+	}
+	return r.Net.String()
+}
+
+func (r denyRule) String() string {
+	if r.Port != 0 {
+		return net.JoinHostPort(r.target(), fmt.Sprint(r.Port))
+	}
+	return r.target()
+}
+
+func (r denyRule) same(o denyRule) bool {
+	return r.Port == o.Port && (r.Limit > 0) == (o.Limit > 0) && r.Net.String() == o.Net.String()
+}
+
+// denyStatus is a rule with what it has dropped so far.
+type denyStatus struct {
+	denyRule
+	denyCounters
+}
+
+// Enforcer is implemented by sources that can drop traffic, not just watch
+// it. Enforcing reports whether they were started to do so.
+type Enforcer interface {
+	Enforcing() bool
+	Block(n *net.IPNet, port uint16, ttl time.Duration) (denyRule, error)
+	Limit(n *net.IPNet, port uint16, rate uint64, ttl time.Duration) (denyRule, error)
+	Unblock(id uint32) error
+	DenyRules() []denyStatus
+}
+
+func (s *bpfSource) Enforcing() bool {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.opts.Enforce
+}
+
+// Block adds a deny rule, or renews the expiry of the same rule if it exists.
+// A zero ttl keeps the rule until it is removed.
+func (s *bpfSource) Block(n *net.IPNet, port uint16, ttl time.Duration) (denyRule, error) {
+	return s.addRule(denyRule{Net: n, Port: port}, ttl)
+}
+
+// Limit adds a limit rule of rate bytes per second, or updates the rate and
+// expiry of the same rule if it exists.
+func (s *bpfSource) Limit(n *net.IPNet, port uint16, rate uint64, ttl time.Duration) (denyRule, error) {
+	if rate == 0 || rate > maxLimitRate {
+		return denyRule{}, fmt.Errorf("limit must be between 1 B/s and %s/s", parseBytes(maxLimitRate))
+	}
+	return s.addRule(denyRule{Net: n, Port: port, Limit: rate}, ttl)
+}
+
+func (s *bpfSource) addRule(rule denyRule, ttl time.Duration) (denyRule, error) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if !s.opts.Enforce {
+		return denyRule{}, errors.New("blocking and limiting need -enforce")
+	}
+	if s.gen == nil {
+		return denyRule{}, fmt.Errorf("eBPF programs not loaded")
+	}
+
+	now := time.Now()
+	rule.Created = now
+	for _, r := range s.deny {
+		if r.same(rule) {
+			rule.ID, rule.Created = r.ID, r.Created
+		}
+	}
+	if rule.ID == 0 {
+		if len(s.deny) >= maxDenyRules {
+			return denyRule{}, fmt.Errorf("at most %d deny rules", maxDenyRules)
+		}
+		s.nextDenyID++
+		rule.ID = s.nextDenyID
+	}
+	if ttl > 0 {
+		rule.Expires = now.Add(ttl)
+	}
+
+	if err := putDenyRule(s.gen.maps(), rule); err != nil {
+		return denyRule{}, err
+	}
+	s.deny[rule.ID] = rule
+	s.denyVersion++
+	return rule, nil
+}
+
+func (s *bpfSource) Unblock(id uint32) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.unblockLocked(id)
+}
+
+// unblockLocked removes rule id. It must be called with configMu held.
+func (s *bpfSource) unblockLocked(id uint32) error {
+	rule, ok := s.deny[id]
+	if !ok {
+		return fmt.Errorf("no deny rule %d", id)
+	}
+	if s.gen != nil {
+		if err := deleteDenyRule(s.gen.maps(), rule); err != nil {
+			return err
+		}
+	}
+	delete(s.deny, id)
+	delete(s.retiredDeny, id)
+	s.denyVersion++
+	return nil
+}
+
+// DenyRules returns the rules by id, with their counters summed across CPUs
+// and generations.
+func (s *bpfSource) DenyRules() []denyStatus {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	rules := make([]denyStatus, 0, len(s.deny))
+	for id, rule := range s.deny {
+		st := denyStatus{denyRule: rule, denyCounters: s.retiredDeny[id]}
+		if s.gen != nil {
+			st.denyCounters = st.denyCounters.add(readDenyCounters(s.gen.maps()[bpfMapDenyStats], id))
+		}
+		rules = append(rules, st)
+	}
+	slices.SortFunc(rules, func(a, b denyStatus) int { return int(a.ID) - int(b.ID) })
+	return rules
+}
+
+// retireDeny keeps the counters of a generation about to be replaced. It
+// must be called with configMu held.
+func (s *bpfSource) retireDeny(g *generation) {
+	for id := range s.deny {
+		s.retiredDeny[id] = s.retiredDeny[id].add(readDenyCounters(g.maps()[bpfMapDenyStats], id))
+	}
+}
+
+// expireDenyRules removes rules past their expiry until the source stops.
+func (s *bpfSource) expireDenyRules() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.removeExpired(now)
+		}
+	}
+}
+
+// removeExpired removes the rules past their expiry at now. Expiry is checked
+// under the same lock as the removal, so a rule renewed in the meantime stays.
+func (s *bpfSource) removeExpired(now time.Time) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	for id, rule := range s.deny {
+		if !rule.Expires.IsZero() && now.After(rule.Expires) {
+			if err := s.unblockLocked(id); err != nil {
+				log.Printf("expiring deny rule %d: %v", id, err)
+			}
+		}
+	}
+}
+
+func readDenyCounters(m *ebpf.Map, id uint32) denyCounters {
+	var perCPU []denyCounters
+	if err := m.Lookup(id, &perCPU); err != nil {
+		return denyCounters{}
+	}
+	var total denyCounters
+	for _, c := range perCPU {
+		total = total.add(c)
+	}
+	return total
+}
+
+// denyKey returns the trie and key a rule is stored under.
+func denyKey(maps map[string]*ebpf.Map, rule denyRule) (*ebpf.Map, any) {
+	v4, v6 := maps[bpfMapDenyV4], maps[bpfMapDenyV6]
+	if rule.Limit > 0 {
+		v4, v6 = maps[bpfMapLimitV4], maps[bpfMapLimitV6]
+	}
+	ones, _ := rule.Net.Mask.Size()
+	if ip4 := rule.Net.IP.To4(); ip4 != nil && len(rule.Net.Mask) == net.IPv4len {
+		key := denyKeyV4{Prefixlen: 16 + uint32(ones), Port: rule.Port}
+		copy(key.Addr[:], ip4)
+		return v4, key
+	}
+	key := denyKeyV6{Prefixlen: 16 + uint32(ones), Port: rule.Port}
+	copy(key.Addr[:], rule.Net.IP.To16())
+	return v6, key
+}
+
+// putDenyRule stores a rule. Its counters and bucket are created first, so the
+// programs never match a rule they cannot apply. The bucket starts full.
+func putDenyRule(maps map[string]*ebpf.Map, rule denyRule) error {
+	stats := maps[bpfMapDenyStats]
+	if err := stats.Update(rule.ID, []denyCounters{{}}, ebpf.UpdateNoExist); err != nil && !errors.Is(err, ebpf.ErrKeyExist) {
+		return fmt.Errorf("deny rule %s: %w", rule, err)
+	}
+	if rule.Limit > 0 {
+		burst := max(rule.Limit, minLimitBurst)
+		state := limitState{Rate: rule.Limit, Burst: burst, Tokens: burst}
+		if err := maps[bpfMapLimitState].Update(rule.ID, state, ebpf.UpdateLock); err != nil {
+			return fmt.Errorf("deny rule %s: %w", rule, err)
+		}
+	}
+	trie, key := denyKey(maps, rule)
+	if err := trie.Put(key, rule.ID); err != nil {
+		return fmt.Errorf("deny rule %s: %w", rule, err)
+	}
+	return nil
+}
+
+func deleteDenyRule(maps map[string]*ebpf.Map, rule denyRule) error {
+	trie, key := denyKey(maps, rule)
+	if err := trie.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return fmt.Errorf("deny rule %s: %w", rule, err)
+	}
+	for _, name := range []string{bpfMapLimitState, bpfMapDenyStats} {
+		if err := maps[name].Delete(rule.ID); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("deny rule %s: %w", rule, err)
+		}
+	}
+	return nil
+}
+
+// writeDenyRules makes a generation's tries hold exactly rules. Rules are
+// added before stale ones are removed, so nothing still denied or limited
+// slips through in between.
+func writeDenyRules(maps map[string]*ebpf.Map, rules map[uint32]denyRule) error {
+	for _, rule := range rules {
+		if err := putDenyRule(maps, rule); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{bpfMapDenyV4, bpfMapDenyV6, bpfMapLimitV4, bpfMapLimitV6} {
+		var key []byte
+		var id uint32
+		var stale [][]byte
+		iter := maps[name].Iterate()
+		for iter.Next(&key, &id) {
+			if _, ok := rules[id]; !ok {
+				stale = append(stale, slices.Clone(key))
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := maps[name].Delete(k); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// limitFlags collects -limit rules given as addr[:port]=rate, e.g.
+// 10.0.0.0/8=5MB or [2001:db8::1]:443=512KB/s.
+type limitFlags []limitSpec
+
+type limitSpec struct {
+	Net  *net.IPNet
+	Port uint16
+	Rate uint64
+}
+
+func (l *limitFlags) String() string {
+	var specs []string
+	for _, spec := range *l {
+		specs = append(specs, fmt.Sprintf("%s=%s/s", denyRule{Net: spec.Net, Port: spec.Port}, parseBytes(spec.Rate)))
+	}
+	return strings.Join(specs, ",")
+}
+
+func (l *limitFlags) Set(value string) error {
+	target, rate, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("%q: want addr[:port]=rate, e.g. 10.0.0.5:443=5MB", value)
+	}
+	var spec limitSpec
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 {
+			return fmt.Errorf("%q: invalid port %q", value, port)
+		}
+		target, spec.Port = host, uint16(p)
+	}
+	nets, err := parseNets(target)
+	if err != nil || len(nets) != 1 {
+		return fmt.Errorf("%q: invalid address %q", value, target)
+	}
+	spec.Net = nets[0]
+	if spec.Rate, ok = parseRate(rate); !ok {
+		return fmt.Errorf("%q: invalid rate %q, want e.g. 512KB or 5MB/s", value, rate)
+	}
+	*l = append(*l, spec)
+	return nil
+}
+
+// parseRate reads a rate in bytes per second such as 5MB, 1.5GB/s or 800 KB,
+// as parseBytes prints them. Units are binary, as everywhere else in ionet.
+func parseRate(value string) (uint64, bool) {
+	s := strings.ToUpper(strings.TrimSuffix(value, "/s"))
+	units := []struct {
+		suffix string
+		scale  float64
+	}{{"GB", GB}, {"MB", MB}, {"KB", KB}, {"B", 1}}
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil || f <= 0 {
+				return 0, false
+			}
+			return uint64(f * u.scale), f*u.scale >= 1
+		}
+	}
+	return 0, false
+}
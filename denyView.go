@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// Offered when blocking or limiting a row. The expiry keeps a rule left
+// behind by mistake from outliving the incident.
+const (
+	defaultBlockExpiry = "1h"
+	defaultLimitRate   = "1MB/s"
+)
+
+// enforcer returns the source as an Enforcer if it was started with -enforce.
+func (m *model) enforcer() Enforcer {
+	if e, ok := m.source.(Enforcer); ok && e.Enforcing() {
+		return e
+	}
+	return nil
+}
+
+// selectable reports whether the current view has a row cursor: the agg
+// view's peers and the deny rules, when there is something to act on them.
+func (m *model) selectable() bool {
+	if m.enforcer() == nil {
+		return false
+	}
+	return m.currentView == "deny" || m.currentView == "agg" && !m.groupByProcess
+}
+
+func (m *model) moveSelection(delta int) {
+	n := len(m.aggKeys)
+	if m.currentView == "deny" {
+		n = len(m.denyIDs)
+	}
+	m.selected = max(min(m.selected+delta, n-1), 0)
+	if m.currentView == "agg" && m.selected < n {
+		m.selectedAgg = m.aggKeys[m.selected]
+	}
+	m.showSelection()
+}
+
+// selectAggRow keeps the cursor on the same peer as the rows are re-sorted;
+// once the peer is gone it stays at the same position.
+func (m *model) selectAggRow() {
+	for i, key := range m.aggKeys {
+		if key == m.selectedAgg {
+			m.selected = i
+			return
+		}
+	}
+	m.selected = max(min(m.selected, len(m.aggKeys)-1), 0)
+	if m.selected < len(m.aggKeys) {
+		m.selectedAgg = m.aggKeys[m.selected]
+	}
+}
+
+// highlightRow renders the selected row in reverse video.
+func (m *model) highlightRow(rows []string) {
+	if m.selected < len(rows) {
+		rows[m.selected] = selectedStyle.Render(ansi.Strip(rows[m.selected]))
+	}
+}
+
+// showSelection scrolls the viewport just enough to show the selected row.
+func (m *model) showSelection() {
+	if m.selected < m.viewport.YOffset {
+		m.viewport.SetYOffset(m.selected)
+	} else if m.selected >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(m.selected - m.viewport.Height + 1)
+	}
+}
+
+// refreshDenyRules reads the rules once per tick for the header and the deny
+// view, and follows the rate each limit rule lets through.
+func (m *model) refreshDenyRules(now time.Time) {
+	e := m.enforcer()
+	if e == nil {
+		return
+	}
+	m.denyRules = e.DenyRules()
+	live := make(map[uint32]*dropMeter, len(m.denyRules))
+	for _, r := range m.denyRules {
+		meter := m.denyRates[r.ID]
+		if meter == nil {
+			meter = &dropMeter{}
+		}
+		meter.update(r.Sent, now)
+		live[r.ID] = meter
+	}
+	m.denyRates = live
+}
+
+func (m *model) updateDenyView() {
+	m.headerView.SetContent(tableHeaderDeny)
+
+	m.denyIDs = m.denyIDs[:0]
+	rows := make([]string, 0, len(m.denyRules))
+	now := time.Now()
+	for _, r := range m.denyRules {
+		m.denyIDs = append(m.denyIDs, r.ID)
+		port := "any"
+		if r.Port != 0 {
+			port = fmt.Sprint(r.Port)
+		}
+		name := "-"
+		if ones, bits := r.Net.Mask.Size(); ones == bits {
+			name = hostName(r.Net.IP)
+		}
+		limit, rate := "-", "-"
+		if r.Limit > 0 {
+			limit = parseBytes(r.Limit) + "/s"
+			rate = parseBytes(uint64(m.denyRates[r.ID].rate)) + "/s"
+		}
+		rows = append(rows, fmt.Sprintf(format_deny,
+			fixedWidth(fmt.Sprint(r.ID), denyIDWidth), coloredSeparator,
+			fixedWidth(r.kind(), denyKindWidth), coloredSeparator,
+			fixedWidth(r.target(), endpointWidth), coloredSeparator,
+			fixedWidth(port, denyPortWidth), coloredSeparator,
+			fixedWidth(name, dnsNameWidth), coloredSeparator,
+			fixedWidth(limit, bytesWidth), coloredSeparator,
+			GreenTextSyle.Render(fixedWidth(rate, bytesWidth)), coloredSeparator,
+			fixedWidth(expiryString(r.Expires, now), durationWidth), coloredSeparator,
+			MagentaStyle.Render(fixedWidth(fmt.Sprint(r.Packets), packetsCountWidth)), coloredSeparator,
+			RedTextSyle.Render(fixedWidth(parseBytes(r.Bytes), bytesWidth)),
+		))
+	}
+	m.selected = max(min(m.selected, len(rows)-1), 0)
+	m.highlightRow(rows)
+	m.viewport.SetContent(lipgloss.JoinVertical(lipgloss.Left, rows...))
+}
+
+func expiryString(expires, now time.Time) string {
+	if expires.IsZero() {
+		return "never"
+	}
+	return expires.Sub(now).Round(time.Second).String()
+}
+
+// blockSelected puts a block command for the selected peer on the command
+// line; running it is the confirmation.
+func (m *model) blockSelected() {
+	if m.selected >= len(m.aggKeys) {
+		return
+	}
+	ip := bytesToIP(m.selectedAgg.IP)
+	m.openPaletteWith(fmt.Sprintf("block %s %s", ip, defaultBlockExpiry))
+	m.setMessage(fmt.Sprintf("enter blocks all traffic with %s, esc cancels | add a port to block only that port, drop the expiry to block until unblocked", ip), false)
+}
+
+// limitSelected puts a limit command for the selected peer on the command
+// line, like blockSelected.
+func (m *model) limitSelected() {
+	if m.selected >= len(m.aggKeys) {
+		return
+	}
+	ip := bytesToIP(m.selectedAgg.IP)
+	m.openPaletteWith(fmt.Sprintf("limit %s %s %s", ip, defaultLimitRate, defaultBlockExpiry))
+	m.setMessage(fmt.Sprintf("enter caps traffic to %s at %s, esc cancels | add a port to limit only that port, drop the expiry to keep the limit", ip, defaultLimitRate), false)
+}
+
+func (m *model) unblockSelected() {
+	e := m.enforcer()
+	if e == nil || m.selected >= len(m.denyIDs) {
+		return
+	}
+	m.unblock(e, m.denyIDs[m.selected])
+}
+
+// runBlock handles "block <ip|cidr> [port] [expiry]" and
+// "limit <ip|cidr> [port] <rate> [expiry]"; the arguments after the address
+// can come in any order.
+func (m *model) runBlock(fields []string) {
+	action := fields[0]
+	usage := "usage: block <ip|cidr> [port] [expiry]"
+	if action == "limit" {
+		usage = "usage: limit <ip|cidr> [port] <rate> [expiry]"
+	}
+	e := m.enforcer()
+	if e == nil {
+		m.setMessage(action+" needs -enforce", true)
+		return
+	}
+	if len(fields) < 2 || len(fields) > 5 {
+		m.setMessage(usage, true)
+		return
+	}
+	nets, err := parseNets(fields[1])
+	if err != nil || len(nets) != 1 {
+		m.setMessage(fmt.Sprintf("%s: invalid address %q", action, fields[1]), true)
+		return
+	}
+	var port uint16
+	var rate uint64
+	var ttl time.Duration
+	for _, f := range fields[2:] {
+		if p, err := strconv.ParseUint(f, 10, 16); err == nil && p > 0 {
+			port = uint16(p)
+		} else if r, ok := parseRate(f); ok && action == "limit" {
+			rate = r
+		} else if d, err := time.ParseDuration(f); err == nil && d > 0 {
+			ttl = d
+		} else {
+			m.setMessage(fmt.Sprintf("%s: %q is not a port, rate or expiry (%s)", action, f, usage), true)
+			return
+		}
+	}
+
+	var rule denyRule
+	if action == "limit" {
+		if rate == 0 {
+			m.setMessage(usage, true)
+			return
+		}
+		rule, err = e.Limit(nets[0], port, rate, ttl)
+	} else {
+		rule, err = e.Block(nets[0], port, ttl)
+	}
+	if err != nil {
+		m.setMessage(fmt.Sprintf("%s: %v", action, err), true)
+		return
+	}
+	until := "until removed"
+	if !rule.Expires.IsZero() {
+		until = "until " + rule.Expires.Format("15:04:05")
+	}
+	if action == "limit" {
+		m.setMessage(fmt.Sprintf("limited %s to %s/s as rule %d %s", rule, parseBytes(rule.Limit), rule.ID, until), false)
+		return
+	}
+	m.setMessage(fmt.Sprintf("blocked %s as rule %d %s", rule, rule.ID, until), false)
+}
+
+// runUnblock handles "unblock <id>" and "unlimit <id>".
+func (m *model) runUnblock(fields []string) {
+	e := m.enforcer()
+	if e == nil {
+		m.setMessage(fields[0]+" needs -enforce", true)
+		return
+	}
+	if len(fields) != 2 {
+		m.setMessage(fmt.Sprintf("usage: %s <id>", fields[0]), true)
+		return
+	}
+	id, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		m.setMessage(fmt.Sprintf("%s: invalid rule id %q", fields[0], fields[1]), true)
+		return
+	}
+	// unblock removes blocks and unlimit limits, so a mistyped id does not
+	// silently lift the other kind of rule.
+	want := "block"
+	if fields[0] == "unlimit" {
+		want = "limit"
+	}
+	for _, r := range e.DenyRules() {
+		if r.ID == uint32(id) && r.kind() != want {
+			m.setMessage(fmt.Sprintf("rule %d is a %s: use un%s %d", id, r.kind(), r.kind(), id), true)
+			return
+		}
+	}
+	m.unblock(e, uint32(id))
+}
+
+func (m *model) unblock(e Enforcer, id uint32) {
+	var desc string
+	for _, r := range m.denyRules {
+		if r.ID == id {
+			desc = fmt.Sprintf("%s %s", r.kind(), r)
+		}
+	}
+	if err := e.Unblock(id); err != nil {
+		m.setMessage(fmt.Sprintf("remove rule: %v", err), true)
+		return
+	}
+	m.setMessage(fmt.Sprintf("removed rule %d (%s)", id, desc), false)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want uint64
+		ok   bool
+	}{
+		{"5MB", 5 * MB, true},
+		{"5mb/s", 5 * MB, true},
+		{"1.5GB/s", 3 * GB / 2, true},
+		{"512KB", 512 * KB, true},
+		{"5.00 MB", 5 * MB, true},
+		{"100B", 100, true},
+		{"0.5B", 0, false},
+		{"0MB", 0, false},
+		{"-1KB", 0, false},
+		{"5", 0, false},
+		{"5TB", 0, false},
+		{"fast", 0, false},
+		{"", 0, false},
+	} {
+		got, ok := parseRate(tt.in)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseRate(%q) = %d, %v; want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLimitFlagsSet(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string // String() of the parsed spec, "" for an error
+	}{
+		{"10.0.0.5=5MB", "10.0.0.5=5.00 MB/s"},
+		{"10.0.0.5:443=5MB/s", "10.0.0.5:443=5.00 MB/s"},
+		{"10.0.0.0/8=1GB", "10.0.0.0/8=1.00 GB/s"},
+		{"[2001:db8::1]:443=512KB", "[2001:db8::1]:443=512.00 KB/s"},
+		{"2001:db8::/32=1MB", "2001:db8::/32=1.00 MB/s"},
+		{"10.0.0.5", ""},
+		{"10.0.0.5:0=5MB", ""},
+		{"10.0.0.5:http=5MB", ""},
+		{"10.0=5MB", ""},
+		{"10.0.0.5,10.0.0.6=5MB", ""},
+		{"10.0.0.5=fast", ""},
+	} {
+		var l limitFlags
+		err := l.Set(tt.in)
+		if (err != nil) != (tt.want == "") {
+			t.Errorf("Set(%q): %v", tt.in, err)
+			continue
+		}
+		if err == nil && l.String() != tt.want {
+			t.Errorf("Set(%q) = %q, want %q", tt.in, l.String(), tt.want)
+		}
+	}
+
+	var l limitFlags
+	for _, v := range []string{"10.0.0.5=5MB", "10.0.0.6:53=1KB"} {
+		if err := l.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l) != 2 || l[1].Port != 53 || l[1].Rate != KB {
+		t.Errorf("repeated -limit = %+v", l)
+	}
+	if got := l.String(); got != "10.0.0.5=5.00 MB/s,10.0.0.6:53=1.00 KB/s" {
+		t.Errorf("String() = %q", got)
+	}
+	var again limitFlags
+	if err := again.Set("10.0.0.6:53=1.00 KB/s"); err != nil || again[0].Rate != KB {
+		t.Errorf("String() output does not parse back: %v", err)
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	s := newBpfSource(loaderOptions{Enforce: true})
+	now := time.Now()
+	_, n, _ := net.ParseCIDR("10.0.0.0/8")
+	for _, r := range []denyRule{
+		{ID: 1, Net: n, Expires: now.Add(-time.Second)},
+		{ID: 2, Net: n, Port: 443, Expires: now.Add(time.Minute)},
+		{ID: 3, Net: n, Limit: MB},
+	} {
+		s.deny[r.ID] = r
+	}
+	s.retiredDeny[1] = denyCounters{Packets: 1}
+
+	s.removeExpired(now)
+	var ids []uint32
+	for _, r := range s.DenyRules() {
+		ids = append(ids, r.ID)
+	}
+	if !slices.Equal(ids, []uint32{2, 3}) {
+		t.Errorf("rules after expiry = %v, want [2 3]", ids)
+	}
+	if _, ok := s.retiredDeny[1]; ok {
+		t.Error("counters of the expired rule kept")
+	}
+
+	// A rule renewed before the sweep runs is judged by its new expiry.
+	r := s.deny[2]
+	r.Expires = now.Add(2 * time.Minute)
+	s.deny[2] = r
+	s.removeExpired(now.Add(90 * time.Second))
+	if _, ok := s.deny[2]; !ok {
+		t.Error("renewed rule removed")
+	}
+}
+
+// fakeEnforcer keeps deny rules in a map.
+type fakeEnforcer struct {
+	*fakeSource
+	rules map[uint32]denyRule
+}
+
+func (s *fakeEnforcer) Enforcing() bool { return true }
+func (s *fakeEnforcer) Block(n *net.IPNet, port uint16, ttl time.Duration) (denyRule, error) {
+	return denyRule{}, nil
+}
+func (s *fakeEnforcer) Limit(n *net.IPNet, port uint16, rate uint64, ttl time.Duration) (denyRule, error) {
+	return denyRule{}, nil
+}
+func (s *fakeEnforcer) Unblock(id uint32) error { delete(s.rules, id); return nil }
+func (s *fakeEnforcer) DenyRules() []denyStatus {
+	var rules []denyStatus
+	for _, r := range s.rules {
+		rules = append(rules, denyStatus{denyRule: r})
+	}
+	return rules
+}
+
+func TestRunUnblockChecksRuleKind(t *testing.T) {
+	_, n, _ := net.ParseCIDR("10.0.0.5/32")
+	for _, tt := range []struct {
+		cmd     string
+		removed bool
+	}{
+		{"unblock 1", true},
+		{"unlimit 1", false},
+		{"unlimit 2", true},
+		{"unblock 2", false},
+	} {
+		e := &fakeEnforcer{fakeSource: newFakeSource(), rules: map[uint32]denyRule{
+			1: {ID: 1, Net: n},
+			2: {ID: 2, Net: n, Limit: MB},
+		}}
+		m := initialModel(e)
+		fields := strings.Fields(tt.cmd)
+		m.runUnblock(fields)
+		id, _ := strconv.Atoi(fields[1])
+		if _, kept := e.rules[uint32(id)]; kept == tt.removed || m.isError == tt.removed {
+			t.Errorf("%s: removed %v, error %v %q; want removed %v", tt.cmd, !kept, m.isError, m.message, tt.removed)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnsGrace keeps a name past its TTL: connections routinely outlive the
+	// record that led to them.
+	dnsGrace    = 10 * time.Minute
+	maxDNSNames = 65536
+)
+
+type dnsName struct {
+	name    string
+	expires time.Time
+}
+
+// dnsNames maps addresses seen in DNS answers to the name that was asked
+// for, so a CDN address shows up as the host the client wanted.
+var (
+	dnsNames   = make(map[[16]byte]dnsName)
+	dnsNamesMu sync.RWMutex
+	// dnsClock is the capture time of the packet a replay last delivered,
+	// in Unix nanoseconds, so names expire in recording time; zero in
+	// live capture, where the wall clock is used.
+	dnsClock atomic.Int64
+)
+
+func dnsNow() time.Time {
+	if ns := dnsClock.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Now()
+}
+
+// readDNS feeds the DNS responses copied by the eBPF programs into
+// dnsNames until rd is closed.
+func readDNS(rd *ringbuf.Reader) {
+	var record ringbuf.Record
+	for {
+		if err := rd.ReadInto(&record); err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+			log.Printf("Error reading from %s: %v", bpfMapDNS, err)
+			continue
+		}
+		sample := record.RawSample
+		if len(sample) < 4 {
+			continue
+		}
+		n := binary.LittleEndian.Uint32(sample)
+		if int(n) > len(sample)-4 {
+			continue
+		}
+		observeDNS(sample[4:4+n], time.Now())
+	}
+}
+
+// observeDNS records the A and AAAA answers of a DNS response under its
+// question name. Responses truncated by the capture still yield the answers
+// that fit.
+func observeDNS(msg []byte, now time.Time) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil || !hdr.Response || hdr.RCode != dnsmessage.RCodeSuccess {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	name := strings.TrimSuffix(q.Name.String(), ".")
+
+	for {
+		ah, err := p.AnswerHeader()
+		if err != nil {
+			return
+		}
+		var ip [16]byte
+		switch ah.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return
+			}
+			copy(ip[:], net.IP(r.A[:]).To16())
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return
+			}
+			ip = r.AAAA
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return
+			}
+			continue
+		}
+		addDNSName(ip, name, now.Add(time.Duration(ah.TTL)*time.Second+dnsGrace))
+	}
+}
+
+func addDNSName(ip [16]byte, name string, expires time.Time) {
+	dnsNamesMu.Lock()
+	defer dnsNamesMu.Unlock()
+	if _, ok := dnsNames[ip]; !ok && len(dnsNames) >= maxDNSNames {
+		now := dnsNow()
+		for k, v := range dnsNames {
+			if now.After(v.expires) {
+				delete(dnsNames, k)
+			}
+		}
+		if len(dnsNames) >= maxDNSNames {
+			return
+		}
+	}
+	dnsNames[ip] = dnsName{name: name, expires: expires}
+}
+
+// hostName is dnsNameFor for display, with "-" for unknown addresses.
+func hostName(ip net.IP) string {
+	if name := dnsNameFor(ip); name != "" {
+		return name
+	}
+	return "-"
+}
+
+// dnsNameFor returns the name ip was last resolved from, or "" when no
+// unexpired answer carried it.
+func dnsNameFor(ip net.IP) string {
+	var key [16]byte
+	copy(key[:], ip.To16())
+	dnsNamesMu.RLock()
+	n, ok := dnsNames[key]
+	dnsNamesMu.RUnlock()
+	if !ok || dnsNow().After(n.expires) {
+		return ""
+	}
+	return n.name
+}
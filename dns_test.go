@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsResponse builds a response to an A query for name carrying answers.
+func dnsResponse(t *testing.T, hdr dnsmessage.Header, name string, answers ...dnsmessage.Resource) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, hdr)
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	b.StartAnswers()
+	for _, a := range answers {
+		var err error
+		switch r := a.Body.(type) {
+		case *dnsmessage.AResource:
+			err = b.AResource(a.Header, *r)
+		case *dnsmessage.AAAAResource:
+			err = b.AAAAResource(a.Header, *r)
+		case *dnsmessage.CNAMEResource:
+			err = b.CNAMEResource(a.Header, *r)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func answer(name string, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   body,
+	}
+}
+
+func resetDNSNames() {
+	dnsNamesMu.Lock()
+	dnsNames = make(map[[16]byte]dnsName)
+	dnsNamesMu.Unlock()
+}
+
+func TestObserveDNS(t *testing.T) {
+	ok := dnsmessage.Header{Response: true}
+	a := func(ip string) *dnsmessage.AResource {
+		var r dnsmessage.AResource
+		copy(r.A[:], net.ParseIP(ip).To4())
+		return &r
+	}
+	aaaa := func(ip string) *dnsmessage.AAAAResource {
+		var r dnsmessage.AAAAResource
+		copy(r.AAAA[:], net.ParseIP(ip))
+		return &r
+	}
+	cname := answer("www.example.com.", 60, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("cdn.example.net.")})
+	full := dnsResponse(t, ok, "www.example.com.", answer("www.example.com.", 60, a("192.0.2.1")),
+		answer("www.example.com.", 60, a("192.0.2.2")))
+
+	for _, tt := range []struct {
+		name string
+		msg  []byte
+		want map[string]string
+	}{
+		{"a", dnsResponse(t, ok, "example.com.", answer("example.com.", 60, a("192.0.2.1"))),
+			map[string]string{"192.0.2.1": "example.com"}},
+		{"aaaa", dnsResponse(t, ok, "example.com.", answer("example.com.", 60, aaaa("2001:db8::1"))),
+			map[string]string{"2001:db8::1": "example.com"}},
+		{"cname chain keeps the question name", dnsResponse(t, ok, "www.example.com.", cname,
+			answer("cdn.example.net.", 60, a("198.51.100.7"))),
+			map[string]string{"198.51.100.7": "www.example.com"}},
+		{"truncated by the capture", full[:len(full)-2],
+			map[string]string{"192.0.2.1": "www.example.com", "192.0.2.2": ""}},
+		{"query", dnsResponse(t, dnsmessage.Header{}, "example.com.", answer("example.com.", 60, a("192.0.2.1"))),
+			map[string]string{"192.0.2.1": ""}},
+		{"nxdomain", dnsResponse(t, dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeNameError}, "example.com.",
+			answer("example.com.", 60, a("192.0.2.1"))),
+			map[string]string{"192.0.2.1": ""}},
+		{"garbage", []byte{0x12, 0x34, 0x81}, map[string]string{"192.0.2.1": ""}},
+	} {
+		resetDNSNames()
+		observeDNS(tt.msg, time.Now())
+		for ip, want := range tt.want {
+			if got := dnsNameFor(net.ParseIP(ip)); got != want {
+				t.Errorf("%s: %s = %q, want %q", tt.name, ip, got, want)
+			}
+		}
+	}
+}
+
+func TestObserveDNSExpires(t *testing.T) {
+	resetDNSNames()
+	msg := dnsResponse(t, dnsmessage.Header{Response: true}, "example.com.",
+		answer("example.com.", 30, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}))
+	ip := net.IPv4(192, 0, 2, 1)
+
+	observeDNS(msg, time.Now().Add(-dnsGrace))
+	if got := dnsNameFor(ip); got != "example.com" {
+		t.Errorf("within TTL: %q, want example.com", got)
+	}
+	observeDNS(msg, time.Now().Add(-dnsGrace-time.Minute))
+	if got := hostName(ip); got != "-" {
+		t.Errorf("past TTL and grace: %q, want -", got)
+	}
+}
+
+func TestObserveDNSReplayClock(t *testing.T) {
+	resetDNSNames()
+	t.Cleanup(func() { dnsClock.Store(0) })
+	msg := dnsResponse(t, dnsmessage.Header{Response: true}, "example.com.",
+		answer("example.com.", 30, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}))
+	ip := net.IPv4(192, 0, 2, 1)
+	captured := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	dnsClock.Store(captured.UnixNano())
+	observeDNS(msg, captured)
+	if got := dnsNameFor(ip); got != "example.com" {
+		t.Errorf("replayed answer: %q, want example.com", got)
+	}
+	dnsClock.Store(captured.Add(30*time.Second + dnsGrace + time.Second).UnixNano())
+	if got := dnsNameFor(ip); got != "" {
+		t.Errorf("past TTL and grace in recording time: %q, want none", got)
+	}
+}
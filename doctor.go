@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+)
+
+// Capability bits from linux/capability.h.
+const (
+	capNetAdmin = 12
+	capSysAdmin = 21
+	capBPF      = 39
+)
+
+// errDoctorSkip marks checks that cannot run because an earlier one failed.
+var errDoctorSkip = errors.New("skipped")
+
+type doctorCheck struct {
+	name  string
+	run   func() error
+	fix   string
+	needs bool // skipped when privileges are missing
+}
+
+// doctorCommand checks each host requirement of live capture and prints a
+// remediation for every failure.
+func doctorCommand(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	bpfObject := fs.String("bpf-object", "", "check this compiled ioNet.o instead of the embedded one")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: ionet doctor [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var uname unix.Utsname
+	if unix.Uname(&uname) == nil {
+		fmt.Printf("kernel %s %s\n", unix.ByteSliceToString(uname.Release[:]), unix.ByteSliceToString(uname.Machine[:]))
+	}
+
+	checks := []doctorCheck{
+		{
+			name: "privileges",
+			run:  checkCapabilities,
+			fix:  "run as root, or grant the binary the capabilities: setcap cap_bpf,cap_net_admin,cap_perfmon+ep ./ionet",
+		},
+		{
+			name: "cgroup v2",
+			run: func() error {
+				_, err := findCgroup2Mount()
+				return err
+			},
+			fix: "boot with systemd.unified_cgroup_hierarchy=1, or mount it: mount -t cgroup2 none /sys/fs/cgroup",
+		},
+		{
+			name: "kernel BTF",
+			run: func() error {
+				_, err := btf.LoadKernelSpec()
+				return err
+			},
+			fix: "use a kernel built with CONFIG_DEBUG_INFO_BTF=y (/sys/kernel/btf/vmlinux must exist)",
+		},
+		{
+			name:  "ring buffer",
+			run:   func() error { return features.HaveMapType(ebpf.RingBuf) },
+			fix:   "upgrade to Linux 5.8 or newer",
+			needs: true,
+		},
+		{
+			name:  "cgroup_skb programs",
+			run:   func() error { return features.HaveProgramType(ebpf.CGroupSKB) },
+			fix:   "enable CONFIG_CGROUP_BPF in the kernel",
+			needs: true,
+		},
+		{
+			name: "tcx attach (-attach tc)",
+			run:  checkTCX,
+			fix:  "upgrade to Linux 6.6 or newer, or use -attach cgroup or -attach xdp",
+		},
+		{
+			name: "eBPF object",
+			run: func() error {
+				_, err := loadCollectionSpec(*bpfObject)
+				return err
+			},
+			fix: "make -C eBPF_module && go build, or point -bpf-object at a compiled ioNet.o",
+		},
+		{
+			name:  "load and verify",
+			run:   func() error { return checkLoad(*bpfObject) },
+			fix:   "see the error above; a verifier log usually means ioNet.o must be rebuilt for this kernel",
+			needs: true,
+		},
+	}
+
+	privileged := checkCapabilities() == nil
+	failed := 0
+	for _, c := range checks {
+		if c.needs && !privileged {
+			fmt.Printf("[skip] %s: needs privileges\n", c.name)
+			continue
+		}
+		err := c.run()
+		if errors.Is(err, errDoctorSkip) {
+			fmt.Printf("[skip] %s: %v\n", c.name, err)
+			continue
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("[FAIL] %s: %v\n       fix: %s\n", c.name, err, c.fix)
+			continue
+		}
+		fmt.Printf("[ ok ] %s\n", c.name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+func checkCapabilities() error {
+	caps, err := effectiveCapabilities()
+	if err != nil {
+		return err
+	}
+	has := func(c uint) bool { return caps&(1<<c) != 0 }
+	var missing []string
+	if !has(capBPF) && !has(capSysAdmin) {
+		missing = append(missing, "CAP_BPF")
+	}
+	if !has(capNetAdmin) {
+		missing = append(missing, "CAP_NET_ADMIN")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w (missing %s)", errNoPrivileges, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func effectiveCapabilities() (uint64, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	return 0, errors.New("no CapEff line in /proc/self/status")
+}
+
+// checkTCX compares the kernel release against 6.6, where tcx links landed.
+func checkTCX() error {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return err
+	}
+	release := unix.ByteSliceToString(uname.Release[:])
+	var major, minor int
+	if _, err := fmt.Sscanf(release, "%d.%d", &major, &minor); err != nil {
+		return fmt.Errorf("unrecognized kernel release %q", release)
+	}
+	if major < 6 || (major == 6 && minor < 6) {
+		return fmt.Errorf("kernel %s is older than 6.6", release)
+	}
+	return nil
+}
+
+// checkLoad loads the programs without attaching them, surfacing verifier
+// and CO-RE failures before a real capture is attempted.
+func checkLoad(path string) error {
+	spec, err := loadCollectionSpec(path)
+	if err != nil {
+		return fmt.Errorf("%w: no eBPF object", errDoctorSkip)
+	}
+	coll, err := ebpf.NewCollection(spec)
+	if err != nil {
+		return classifyLoadError(spec, err)
+	}
+	coll.Close()
+	return nil
+}
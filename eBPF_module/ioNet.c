@@ -4,10 +4,66 @@
 #include <bpf/bpf_endian.h>
 #include <bpf/bpf_core_read.h>
 
+#define IPPROTO_ICMP 1
 #define IPPROTO_TCP 6
 #define IPPROTO_UDP 17
+#define IPPROTO_ICMPV6 58
+#define IPPROTO_HOPOPTS  0
+#define IPPROTO_ROUTING  43
+#define IPPROTO_FRAGMENT 44
+#define IPPROTO_AH       51
+#define IPPROTO_DSTOPTS  60
 #define AF_INET 	2	
 #define AF_INET6	10
+#define TASK_COMM_LEN 16
+
+#define ETH_P_IP      0x0800
+#define ETH_P_IPV6    0x86DD
+#define ETH_P_8021Q   0x8100
+#define ETH_P_8021AD  0x88A8
+#define PACKET_HOST   0
+#define TC_ACT_UNSPEC (-1)
+#define TC_ACT_SHOT   2
+#define EEXIST        17
+#define IP_MF         0x2000
+#define IP_OFFSET     0x1FFF
+#define IP6_OFFSET    0xFFF8
+
+#define DNS_PORT        53
+/* DNS response bytes copied to dns_ring; enough for the answers of all but
+ * the largest responses. */
+#define DNS_MAX_PAYLOAD 512
+/* Bytes copied from the first payload of outbound HTTP and TLS connections;
+ * a full-sized segment, since post-quantum key shares push the SNI of a
+ * ClientHello past 1 KiB. */
+#define HELLO_MAX_PAYLOAD 1500
+#define HTTP_PORT       80
+#define HTTPS_PORT      443
+
+#define ICMP_ECHOREPLY      0
+#define ICMP_DEST_UNREACH   3
+#define ICMP_FRAG_NEEDED    4
+#define ICMP_ECHO           8
+#define ICMPV6_PKT_TOOBIG   2
+#define ICMPV6_ECHO_REQUEST 128
+#define ICMPV6_ECHO_REPLY   129
+
+/* Longest IPv6 extension header chain followed to the transport header. */
+#define IPV6_MAX_EXT_HEADERS 6
+
+#define TCP_FLAG_FIN  0x01
+#define TCP_FLAG_SYN  0x02
+#define TCP_FLAG_RST  0x04
+#define TCP_FLAG_ACK  0x10
+/* The flags kept in flow keys: enough to follow connection state without
+ * splitting flows on PSH and friends. */
+#define TCP_FLOW_FLAGS (TCP_FLAG_FIN | TCP_FLAG_SYN | TCP_FLAG_RST | TCP_FLAG_ACK)
+
+/* traffic_event_t.flags */
+#define EVENT_FRAGMENT   (1 << 0) /* the packet is an IP fragment */
+#define EVENT_NO_L4      (1 << 1) /* no transport header: a non-first
+                                   * fragment, or a chain longer than
+                                   * IPV6_MAX_EXT_HEADERS */
 
 #ifdef DEBUG
 #define MAX_IP_STR_LEN 16
@@ -15,6 +71,18 @@
 
 char LICENSE[] SEC("license") = "Dual BSD/GPL";
 
+#define CAPTURE_FLOWS 0
+#define CAPTURE_RAW   1
+
+/* Set by userspace per cgroup attachment so events can be traced back to it. */
+const volatile __u32 attach_id = 0;
+/* CAPTURE_FLOWS accumulates per-flow counters in flow_stats, CAPTURE_RAW
+ * emits one ring-buffer record per packet. */
+const volatile __u8 capture_mode = CAPTURE_FLOWS;
+/* Set by -enforce: packets matching a deny rule, or sent over a limit rule's
+ * rate, are dropped. Left at 0 the rule lookups are pruned by the verifier. */
+const volatile __u8 enforce = 0;
+
 struct traffic_event_t {
     __u8 protocol;
     char direction;
@@ -28,13 +96,250 @@ struct traffic_event_t {
     __u32 family;
     __u32 pkttype;
     __u64 bytes;
+    __u32 attach_id;
+    __u32 uid;
+    __u64 cgroup_id;
+    __u64 cookie;
+    __u32 pid;
+    char comm[TASK_COMM_LEN];
+    __u32 sample_rate;
+    __u64 ts_ns;          /* CLOCK_MONOTONIC, raw mode only */
+    __u8 flags;           /* EVENT_* */
+    __u8 tcp_flags;       /* TCP header byte 13: FIN SYN RST PSH ACK URG ECE CWR */
+    __u32 seq;            /* TCP only, host byte order, raw mode only */
+    __u32 ack;
+    __u16 window;
 } __attribute__((packed));
 
+#define FILTER_PROTO  (1 << 0)
+#define FILTER_SPORT  (1 << 1)
+#define FILTER_DPORT  (1 << 2)
+#define FILTER_SRC    (1 << 3)
+#define FILTER_DST    (1 << 4)
+#define FILTER_DIR    (1 << 5)
+
+/* Written by userspace when a TUI filter can be evaluated here; flags = 0
+ * lets everything through. sample_rate > 1 keeps roughly one packet in
+ * sample_rate. */
+struct filter_config_t {
+    __u32 flags;
+    __u8 direction;
+    __u8 pad[3];
+    __u64 protocols[4];
+    __u16 sport_min;
+    __u16 sport_max;
+    __u16 dport_min;
+    __u16 dport_max;
+    __u32 sample_rate;
+};
+
+struct lpm_v4_key_t {
+    __u32 prefixlen;
+    __u8 addr[4];
+};
+
+struct lpm_v6_key_t {
+    __u32 prefixlen;
+    __u8 addr[16];
+};
+
+#define SOCK_CONNECT 1
+#define SOCK_SENDMSG 2
+#define SOCK_BIND    3
+
+/* A connect(), an addressed UDP sendmsg() or a bind() made by a task in an
+ * attached cgroup. addr and port are the destination, or the bound local
+ * address for SOCK_BIND; an IPv4 address fills the first 4 bytes. */
+struct sock_event_t {
+    __u8 kind;            /* SOCK_* */
+    __u8 protocol;
+    __u16 port;           /* host byte order */
+    __u32 family;
+    __u8 addr[16];
+    __u32 pid;
+    __u32 uid;
+    __u64 cgroup_id;
+    __u64 cookie;
+    __u64 ts_ns;
+    __u32 attach_id;
+    char comm[TASK_COMM_LEN];
+} __attribute__((packed));
+
+struct flow_val_t {
+    __u64 bytes;
+    __u64 packets;
+};
+
+struct vlan_tag_t {
+    __be16 tci;
+    __be16 encapsulated_proto;
+};
+
+struct sock_owner_t {
+    __u32 pid;
+    char comm[TASK_COMM_LEN];
+};
+
 struct {
     __uint(type, BPF_MAP_TYPE_RINGBUF);
     __uint(max_entries, 1 << 24);
 } traffic_ring SEC(".maps");
 
+/* The payload of a DNS response, for userspace to learn which names the
+ * addresses it sees were resolved from. TCP payloads start after the
+ * two-byte length prefix. */
+struct dns_record_t {
+    __u32 len;
+    __u8 data[DNS_MAX_PAYLOAD];
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 1 << 20);
+} dns_ring SEC(".maps");
+
+/* The start of a TLS ClientHello or an HTTP request, with the event of the
+ * packet that carried it so userspace can tie the server name to the
+ * connection. */
+struct hello_record_t {
+    struct traffic_event_t event;
+    __u32 len;
+    __u8 data[HELLO_MAX_PAYLOAD];
+} __attribute__((packed));
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 1 << 21);
+} hello_ring SEC(".maps");
+
+/* keyed by a traffic_event_t with bytes zeroed, summed per CPU by userspace */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_PERCPU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, struct traffic_event_t);
+    __type(value, struct flow_val_t);
+} flow_stats SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 1 << 20);
+} sock_ring SEC(".maps");
+
+/* socket cookie -> creating task, filled in process context at socket creation */
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, __u64);
+    __type(value, struct sock_owner_t);
+} sock_owner SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct filter_config_t);
+} filter_config SEC(".maps");
+
+/* Indexes into counters, summed across CPUs by userspace. */
+enum {
+    COUNTER_EMIT_FAILED,   /* ring buffer full or flow table update failed */
+    COUNTER_SHORT_PACKET,  /* headers truncated, packet not reported */
+    COUNTER_MAX,
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, COUNTER_MAX);
+    __type(key, __u32);
+    __type(value, __u64);
+} counters SEC(".maps");
+
+static __always_inline void count(__u32 idx) {
+    __u64 *value = bpf_map_lookup_elem(&counters, &idx);
+    if (value)
+        (*value)++;
+}
+
+#define LPM_MAP(name, key_t)                       \
+struct {                                           \
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);           \
+    __uint(max_entries, 256);                      \
+    __uint(map_flags, BPF_F_NO_PREALLOC);          \
+    __type(key, key_t);                            \
+    __type(value, __u8);                           \
+} name SEC(".maps");
+
+LPM_MAP(filter_src_v4, struct lpm_v4_key_t)
+LPM_MAP(filter_dst_v4, struct lpm_v4_key_t)
+LPM_MAP(filter_src_v6, struct lpm_v6_key_t)
+LPM_MAP(filter_dst_v6, struct lpm_v6_key_t)
+
+/* Deny and limit rules match the remote address by prefix and optionally
+ * the remote port. The port comes first in the key and is always matched in
+ * full, so one trie holds both kinds: rules for any port are stored with
+ * port 0. */
+struct deny_v4_key_t {
+    __u32 prefixlen;      /* 16 + address prefix */
+    __u16 port;
+    __u8 addr[4];
+    __u8 pad[2];
+};
+
+struct deny_v6_key_t {
+    __u32 prefixlen;
+    __u16 port;
+    __u8 addr[16];
+    __u8 pad[2];
+};
+
+struct deny_stats_t {
+    __u64 packets;        /* dropped */
+    __u64 bytes;
+    __u64 sent;           /* bytes let through by a limit rule */
+};
+
+/* Token bucket of a limit rule, refilled at rate up to burst. */
+struct limit_state_t {
+    struct bpf_spin_lock lock;
+    __u32 pad;
+    __u64 rate;           /* bytes per second */
+    __u64 burst;          /* bytes */
+    __u64 tokens;
+    __u64 last_ns;
+};
+
+#define MAX_DENY_RULES 1024
+#define NSEC_PER_SEC  1000000000ULL
+
+#define DENY_MAP(name, key_t)                      \
+struct {                                           \
+    __uint(type, BPF_MAP_TYPE_LPM_TRIE);           \
+    __uint(max_entries, MAX_DENY_RULES);           \
+    __uint(map_flags, BPF_F_NO_PREALLOC);          \
+    __type(key, key_t);                            \
+    __type(value, __u32);  /* rule id */           \
+} name SEC(".maps");
+
+DENY_MAP(deny_v4, struct deny_v4_key_t)
+DENY_MAP(deny_v6, struct deny_v6_key_t)
+DENY_MAP(limit_v4, struct deny_v4_key_t)
+DENY_MAP(limit_v6, struct deny_v6_key_t)
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, MAX_DENY_RULES);
+    __type(key, __u32);
+    __type(value, struct limit_state_t);
+} limit_state SEC(".maps");
+
+/* Counters per rule id; userspace creates the entries before the rule. */
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_HASH);
+    __uint(max_entries, MAX_DENY_RULES);
+    __type(key, __u32);
+    __type(value, struct deny_stats_t);
+} deny_stats SEC(".maps");
+
 static __always_inline int parse_ports(void *transport, void *data_end, __u8 proto, __u16 *sport, __u16 *dport) {
     if (proto == IPPROTO_TCP) {
         struct tcphdr *tcph = transport;
@@ -50,11 +355,44 @@ static __always_inline int parse_ports(void *transport, void *data_end, __u8 pro
         *sport = bpf_ntohs(udph->source);
         *dport = bpf_ntohs(udph->dest);
         return 0;
+    } else if (proto == IPPROTO_ICMP || proto == IPPROTO_ICMPV6) {
+        /* ICMP has no ports: sport carries type << 8 | code, dport the echo
+         * identifier or the MTU reported by a too-big message. */
+        __u8 *icmp = transport;
+        if ((void *)(icmp + 8) > data_end)
+            return -1;
+        __u8 type = icmp[0], code = icmp[1];
+        *sport = (__u16)type << 8 | code;
+        *dport = 0;
+        if (proto == IPPROTO_ICMP) {
+            if (type == ICMP_ECHO || type == ICMP_ECHOREPLY)
+                *dport = (__u16)icmp[4] << 8 | icmp[5];
+            else if (type == ICMP_DEST_UNREACH && code == ICMP_FRAG_NEEDED)
+                *dport = (__u16)icmp[6] << 8 | icmp[7];
+        } else {
+            if (type == ICMPV6_ECHO_REQUEST || type == ICMPV6_ECHO_REPLY) {
+                *dport = (__u16)icmp[4] << 8 | icmp[5];
+            } else if (type == ICMPV6_PKT_TOOBIG) {
+                __u32 mtu = (__u32)icmp[4] << 24 | (__u32)icmp[5] << 16 | (__u32)icmp[6] << 8 | icmp[7];
+                *dport = mtu > 0xffff ? 0xffff : mtu;
+            }
+        }
+        return 0;
     }
 
     return -1; 
 }
 
+static __always_inline void parse_tcp(void *transport, void *data_end, struct traffic_event_t *event) {
+    struct tcphdr *tcph = transport;
+    if ((void *)(tcph + 1) > data_end)
+        return;
+    event->tcp_flags = ((__u8 *)tcph)[13];
+    event->seq = bpf_ntohl(tcph->seq);
+    event->ack = bpf_ntohl(tcph->ack_seq);
+    event->window = bpf_ntohs(tcph->window);
+}
+
 
 #ifdef DEBUG
 static __always_inline void print_ip(__u32 ip, char *ip_str) {
@@ -68,107 +406,659 @@ static __always_inline void print_ip(__u32 ip, char *ip_str) {
 }
 #endif
 
-static __always_inline int parse_packet(struct __sk_buff *skb, bool is_ingress) {
-    __u16 sport , dport ;
-    __u8 proto = 0;
-    __u32 saddr = 0;
-    __u32 daddr = 0;
-    __u8 saddr_v6[16] = {};
-    __u8 daddr_v6[16] = {};
-    __u32 family = skb->family;
+/* Follows the IPv6 extension headers starting at *cursor with next header
+ * *nexthdr. On return *nexthdr is the transport protocol and *cursor points
+ * at its header, unless EVENT_NO_L4 was set. Returns -1 when a header is
+ * truncated. */
+static __always_inline int skip_ipv6_ext(void **cursor, void *data_end, __u8 *nexthdr, __u8 *flags) {
+    #pragma unroll
+    for (int i = 0; i < IPV6_MAX_EXT_HEADERS; i++) {
+        switch (*nexthdr) {
+        case IPPROTO_HOPOPTS:
+        case IPPROTO_ROUTING:
+        case IPPROTO_DSTOPTS: {
+            struct ipv6_opt_hdr *opt = *cursor;
+            if ((void *)(opt + 1) > data_end)
+                return -1;
+            *nexthdr = opt->nexthdr;
+            *cursor += (opt->hdrlen + 1) * 8;
+            break;
+        }
+        case IPPROTO_AH: {
+            struct ip_auth_hdr *ah = *cursor;
+            if ((void *)(ah + 1) > data_end)
+                return -1;
+            *nexthdr = ah->nexthdr;
+            *cursor += (ah->hdrlen + 2) * 4;
+            break;
+        }
+        case IPPROTO_FRAGMENT: {
+            struct frag_hdr *fh = *cursor;
+            if ((void *)(fh + 1) > data_end)
+                return -1;
+            *flags |= EVENT_FRAGMENT;
+            *nexthdr = fh->nexthdr;
+            *cursor += sizeof(*fh);
+            if (fh->frag_off & bpf_htons(IP6_OFFSET)) {
+                *flags |= EVENT_NO_L4;
+                return 0;
+            }
+            break;
+        }
+        default:
+            return 0;
+        }
+    }
 
-    void *data = (void *)(long)skb->data;
-    void *data_end = (void *)(long)skb->data_end;
+    switch (*nexthdr) {
+    case IPPROTO_HOPOPTS:
+    case IPPROTO_ROUTING:
+    case IPPROTO_DSTOPTS:
+    case IPPROTO_AH:
+    case IPPROTO_FRAGMENT:
+        *flags |= EVENT_NO_L4;
+    }
+    return 0;
+}
+
+/* Fills the L3/L4 fields of event from an IP header at data and points l4
+ * at the transport header, if there is one. Returns -1 when the headers are
+ * truncated and the packet should not be reported. */
+static __always_inline int parse_l3(void *data, void *data_end, __u32 family, struct traffic_event_t *event, void **l4) {
+    __u16 sport = 0, dport = 0;
+    void *transport;
 
     if (family == AF_INET) {
         struct iphdr *iph = data;
         if ((void *)(iph + 1) > data_end)
-            return 0;
-
-        proto = iph->protocol;
-        saddr = iph->saddr;
-        daddr = iph->daddr;
+            return -1;
 
-        void *transport = (void *)iph + iph->ihl * 4;
-        if (transport + 4 > data_end)
-            return 0;
+        event->protocol = iph->protocol;
+        event->saddr = iph->saddr;
+        event->daddr = iph->daddr;
+        if (iph->frag_off & bpf_htons(IP_MF | IP_OFFSET))
+            event->flags |= EVENT_FRAGMENT;
+        if (iph->frag_off & bpf_htons(IP_OFFSET))
+            event->flags |= EVENT_NO_L4;
 
-        if (parse_ports(transport, data_end, proto, &sport, &dport) < 0){
-            sport = 0;
-            dport = 0;
-        }
-        
+        transport = (void *)iph + iph->ihl * 4;
     } else if (family == AF_INET6) {
         struct ipv6hdr *ip6h = data;
         if ((void *)(ip6h + 1) > data_end)
-            return 0;
+            return -1;
+
+        __builtin_memcpy(event->saddr_v6, &ip6h->saddr, 16);
+        __builtin_memcpy(event->daddr_v6, &ip6h->daddr, 16);
 
-        proto = ip6h->nexthdr;
-        __builtin_memcpy(saddr_v6, &ip6h->saddr, 16);
-        __builtin_memcpy(daddr_v6, &ip6h->daddr, 16);
+        __u8 nexthdr = ip6h->nexthdr;
+        __u8 flags = 0;
+        transport = ip6h + 1;
+        if (skip_ipv6_ext(&transport, data_end, &nexthdr, &flags) < 0)
+            return -1;
+        event->protocol = nexthdr;
+        event->flags |= flags;
+    } else {
+        event->family = family;
+        return 0;
+    }
 
-        void *transport = (void *)(ip6h + 1);
+    if (!(event->flags & EVENT_NO_L4)) {
         if (transport + 4 > data_end)
-            return 0;
+            return -1;
 
-        if (parse_ports(transport, data_end, proto, &sport, &dport) < 0){
+        if (parse_ports(transport, data_end, event->protocol, &sport, &dport) < 0){
             sport = 0;
             dport = 0;
         }
+        if (event->protocol == IPPROTO_TCP)
+            parse_tcp(transport, data_end, event);
+        *l4 = transport;
+    }
+
+    event->family = family;
+    event->sport = sport;
+    event->dport = dport;
+    return 0;
+}
+
+/* Returns the address family of the frame at data and points l3 at the IP
+ * header, skipping up to two VLAN tags. Returns 0 for non-IP frames. */
+static __always_inline __u32 parse_eth(void *data, void *data_end, void **l3) {
+    struct ethhdr *eth = data;
+    if ((void *)(eth + 1) > data_end) {
+        count(COUNTER_SHORT_PACKET);
+        return 0;
+    }
+
+    __u16 proto = eth->h_proto;
+    void *cursor = eth + 1;
+
+    #pragma unroll
+    for (int i = 0; i < 2; i++) {
+        if (proto != bpf_htons(ETH_P_8021Q) && proto != bpf_htons(ETH_P_8021AD))
+            break;
+        struct vlan_tag_t *vlan = cursor;
+        if ((void *)(vlan + 1) > data_end) {
+            count(COUNTER_SHORT_PACKET);
+            return 0;
+        }
+        proto = vlan->encapsulated_proto;
+        cursor = vlan + 1;
+    }
+
+    *l3 = cursor;
+    if (proto == bpf_htons(ETH_P_IP))
+        return AF_INET;
+    if (proto == bpf_htons(ETH_P_IPV6))
+        return AF_INET6;
+    return 0;
+}
+
+static __always_inline void add_socket_info(struct __sk_buff *skb, struct traffic_event_t *event) {
+    event->uid = bpf_get_socket_uid(skb);
+    event->cgroup_id = bpf_skb_cgroup_id(skb);
+    __u64 cookie = bpf_get_socket_cookie(skb);
+    event->cookie = cookie;
+
+    struct sock_owner_t *owner = bpf_map_lookup_elem(&sock_owner, &cookie);
+    if (owner) {
+        event->pid = owner->pid;
+        __builtin_memcpy(event->comm, owner->comm, TASK_COMM_LEN);
+    }
+}
+
+static __always_inline bool addr_matches(__u32 family, __u32 v4, __u8 *v6, void *trie_v4, void *trie_v6) {
+    if (family == AF_INET) {
+        struct lpm_v4_key_t key = { .prefixlen = 32 };
+        __builtin_memcpy(key.addr, &v4, 4);
+        return bpf_map_lookup_elem(trie_v4, &key) != 0;
+    }
+    struct lpm_v6_key_t key = { .prefixlen = 128 };
+    __builtin_memcpy(key.addr, v6, 16);
+    return bpf_map_lookup_elem(trie_v6, &key) != 0;
+}
+
+static __always_inline bool filter_allows(struct traffic_event_t *event) {
+    __u32 zero = 0;
+    struct filter_config_t *cfg = bpf_map_lookup_elem(&filter_config, &zero);
+    if (!cfg)
+        return true;
+
+    __u32 rate = cfg->sample_rate;
+    if (rate > 1 && bpf_get_prandom_u32() % rate)
+        return false;
+    event->sample_rate = rate > 1 ? rate : 1;
+    if (!cfg->flags)
+        return true;
+
+    __u32 flags = cfg->flags;
+    if (flags & FILTER_PROTO) {
+        __u8 proto = event->protocol;
+        if (!(cfg->protocols[proto >> 6] & (1ULL << (proto & 63))))
+            return false;
+    }
+    /* ICMP type and code are not ports; port filters see them as 0. */
+    __u16 sport = event->sport, dport = event->dport;
+    if (event->protocol == IPPROTO_ICMP || event->protocol == IPPROTO_ICMPV6)
+        sport = dport = 0;
+    if ((flags & FILTER_SPORT) && (sport < cfg->sport_min || sport > cfg->sport_max))
+        return false;
+    if ((flags & FILTER_DPORT) && (dport < cfg->dport_min || dport > cfg->dport_max))
+        return false;
+    if ((flags & FILTER_DIR) && event->direction != cfg->direction)
+        return false;
+    if ((flags & FILTER_SRC) &&
+        !addr_matches(event->family, event->saddr, event->saddr_v6, &filter_src_v4, &filter_src_v6))
+        return false;
+    if ((flags & FILTER_DST) &&
+        !addr_matches(event->family, event->daddr, event->daddr_v6, &filter_dst_v4, &filter_dst_v6))
+        return false;
+    return true;
+}
+
+/* Returns the id of the rule in the tries matching the remote end of event,
+ * 0 if none. A rule for the remote port wins over one for any port. */
+static __always_inline __u32 deny_rule(struct traffic_event_t *event, void *trie_v4, void *trie_v6) {
+    bool in = event->direction == 'i';
+    __u16 port = in ? event->sport : event->dport;
+    if ((event->protocol != IPPROTO_TCP && event->protocol != IPPROTO_UDP) || (event->flags & EVENT_NO_L4))
+        port = 0;
+
+    __u32 *id;
+    if (event->family == AF_INET) {
+        struct deny_v4_key_t key = { .prefixlen = 16 + 32, .port = port };
+        __u32 addr = in ? event->saddr : event->daddr;
+        __builtin_memcpy(key.addr, &addr, 4);
+        id = bpf_map_lookup_elem(trie_v4, &key);
+        if (!id && port) {
+            key.port = 0;
+            id = bpf_map_lookup_elem(trie_v4, &key);
+        }
     } else {
-        sport = 0;
-        dport = 0;
+        struct deny_v6_key_t key = { .prefixlen = 16 + 128, .port = port };
+        __builtin_memcpy(key.addr, in ? event->saddr_v6 : event->daddr_v6, 16);
+        id = bpf_map_lookup_elem(trie_v6, &key);
+        if (!id && port) {
+            key.port = 0;
+            id = bpf_map_lookup_elem(trie_v6, &key);
+        }
     }
+    return id ? *id : 0;
+}
 
+/* Reports whether event is to be dropped, counting it against its rule. */
+static __always_inline bool denied(struct traffic_event_t *event) {
+    if (!enforce)
+        return false;
+    __u32 id = deny_rule(event, &deny_v4, &deny_v6);
+    if (!id)
+        return false;
+    struct deny_stats_t *stats = bpf_map_lookup_elem(&deny_stats, &id);
+    if (stats) {
+        stats->packets++;
+        stats->bytes += event->bytes;
+    }
+    return true;
+}
 
+/* Reports whether an outgoing event exceeds the budget of its limit rule.
+ * Packets within budget are counted as sent, the others as dropped. */
+static __always_inline bool over_limit(struct traffic_event_t *event) {
+    if (!enforce || event->direction != 'o')
+        return false;
+    __u32 id = deny_rule(event, &limit_v4, &limit_v6);
+    if (!id)
+        return false;
+    struct limit_state_t *st = bpf_map_lookup_elem(&limit_state, &id);
+    struct deny_stats_t *stats = bpf_map_lookup_elem(&deny_stats, &id);
+    if (!st || !stats)
+        return false;
+
+    __u64 now = bpf_ktime_get_ns();
+    bool drop;
+    bpf_spin_lock(&st->lock);
+    /* Another CPU may have taken the lock with a later timestamp. The clock
+     * only moves on once a whole token was earned, so closely spaced packets
+     * still refill the bucket. userspace caps rate so the product fits. */
+    if (now > st->last_ns) {
+        __u64 elapsed = now - st->last_ns;
+        __u64 earned = elapsed >= NSEC_PER_SEC ? st->burst : elapsed * st->rate / NSEC_PER_SEC;
+        if (earned) {
+            st->tokens += earned;
+            if (st->tokens > st->burst)
+                st->tokens = st->burst;
+            st->last_ns = now;
+        }
+    }
+    drop = st->tokens < event->bytes;
+    if (!drop)
+        st->tokens -= event->bytes;
+    bpf_spin_unlock(&st->lock);
+
+    if (drop) {
+        stats->packets++;
+        stats->bytes += event->bytes;
+    } else {
+        stats->sent += event->bytes;
+    }
+    return drop;
+}
+
+static __always_inline void submit_event(struct traffic_event_t *event) {
+    if (!filter_allows(event))
+        return;
 
-    
     #ifdef DEBUG
     char src_ip_str[MAX_IP_STR_LEN];
     char dst_ip_str[MAX_IP_STR_LEN];
-    print_ip(bpf_ntohl(saddr), src_ip_str);
-    print_ip(bpf_ntohl(daddr), dst_ip_str);
+    print_ip(bpf_ntohl(event->saddr), src_ip_str);
+    print_ip(bpf_ntohl(event->daddr), dst_ip_str);
+    bpf_printk("IP %s:%d -> %s:%d proto=%d  dir=%c len=%d type=%d fam=%d", src_ip_str, event->sport, dst_ip_str, event->dport ,
+        event->protocol, event->direction, event->bytes, event->pkttype, event->family
+    );
     #endif
-     
-    struct traffic_event_t event = {
-        .direction = is_ingress ? 'i' : 'o',
-        .protocol = proto,
-        .saddr = saddr,
-        .daddr = daddr,
-        .sport = sport,
-        .dport = dport,
-        .family = family,
-        .pkttype = skb->pkt_type,
-        .bytes = skb->len,
-        .ifindex = skb->ifindex,
-    };
-    if (family == AF_INET6) {
-        __builtin_memcpy(event.saddr_v6, saddr_v6, 16);
-        __builtin_memcpy(event.daddr_v6, daddr_v6, 16);
+
+    if (capture_mode == CAPTURE_FLOWS) {
+        __u64 len = event->bytes;
+        event->bytes = 0;
+        event->tcp_flags &= TCP_FLOW_FLAGS;
+        event->seq = 0;
+        event->ack = 0;
+        event->window = 0;
+        struct flow_val_t *val = bpf_map_lookup_elem(&flow_stats, event);
+        if (val) {
+            val->bytes += len;
+            val->packets++;
+        } else {
+            struct flow_val_t init = { .bytes = len, .packets = 1 };
+            /* -EEXIST just means another CPU inserted the flow first */
+            long err = bpf_map_update_elem(&flow_stats, event, &init, BPF_NOEXIST);
+            if (err && err != -EEXIST)
+                count(COUNTER_EMIT_FAILED);
+        }
+        return;
     }
 
-    #ifdef DEBUG
-    bpf_printk("IP %s:%d -> %s:%d proto=%d  dir=%d len=%d type=%d fam=%d", src_ip_str, event.sport, dst_ip_str, event.dport ,
-        event.protocol,  is_ingress , event.bytes ,event.pkttype,event.family
-    );
-    #endif
-    
-    if (bpf_ringbuf_output(&traffic_ring, &event, sizeof(event), BPF_RB_FORCE_WAKEUP)) {
-        bpf_printk("Failed to send event to ringbuf");
+    event->ts_ns = bpf_ktime_get_ns();
+    if (bpf_ringbuf_output(&traffic_ring, event, sizeof(*event), BPF_RB_FORCE_WAKEUP))
+        count(COUNTER_EMIT_FAILED);
+}
+
+/* Returns the offset of the TCP payload from start, 0 for other protocols. */
+static __always_inline __u32 tcp_payload_offset(struct traffic_event_t *event, void *start, void *l4, void *data_end) {
+    if (!l4 || event->protocol != IPPROTO_TCP)
+        return 0;
+    struct tcphdr *tcph = l4;
+    if ((void *)(tcph + 1) > data_end)
+        return 0;
+    return l4 - start + tcph->doff * 4;
+}
+
+/* Returns the offset of the payload from start when the packet is a DNS
+ * response, 0 otherwise. */
+static __always_inline __u32 dns_payload_offset(struct traffic_event_t *event, void *start, void *l4, void *data_end) {
+    if (!l4 || event->sport != DNS_PORT)
+        return 0;
+    if (event->protocol == IPPROTO_UDP)
+        return l4 - start + sizeof(struct udphdr);
+    __u32 off = tcp_payload_offset(event, start, l4, data_end);
+    return off ? off + 2 : 0;
+}
+
+/* Returns avail clamped to [1, max] as a load size. The verifier does not
+ * carry the callers' off < len over to len - off, so the lower bound has to
+ * be rebuilt here; the barrier keeps clang from folding it back into their
+ * range checks. */
+static __always_inline __u32 load_len(__u32 avail, __u32 max) {
+    __u32 len = avail - 1;
+    asm volatile("" : "+r"(len));
+    if (len > max - 1)
+        len = max - 1;
+    return len + 1;
+}
+
+/* Copies up to DNS_MAX_PAYLOAD bytes from off to dns_ring. A full ring only
+ * costs names, so it is not counted as a drop. */
+static __always_inline void capture_dns_skb(struct __sk_buff *skb, __u32 off) {
+    if (!off || off >= skb->len)
+        return;
+    __u32 len = load_len(skb->len - off, DNS_MAX_PAYLOAD);
+
+    struct dns_record_t *rec = bpf_ringbuf_reserve(&dns_ring, sizeof(*rec), 0);
+    if (!rec)
+        return;
+    if (bpf_skb_load_bytes(skb, off, rec->data, len) < 0) {
+        bpf_ringbuf_discard(rec, 0);
+        return;
     }
+    rec->len = len;
+    bpf_ringbuf_submit(rec, 0);
+}
 
-    return 0;
+static __always_inline void capture_dns_xdp(struct xdp_md *ctx, __u32 off, __u32 frame_len) {
+    if (!off || off >= frame_len)
+        return;
+    __u32 len = load_len(frame_len - off, DNS_MAX_PAYLOAD);
+
+    struct dns_record_t *rec = bpf_ringbuf_reserve(&dns_ring, sizeof(*rec), 0);
+    if (!rec)
+        return;
+    if (bpf_xdp_load_bytes(ctx, off, rec->data, len) < 0) {
+        bpf_ringbuf_discard(rec, 0);
+        return;
+    }
+    rec->len = len;
+    bpf_ringbuf_submit(rec, 0);
+}
+
+/* Copies the payload at off to hello_ring when it starts a TLS ClientHello
+ * or an HTTP request on its way to port 443 or 80. Only those first bytes
+ * are looked at, so the rest of the stream costs one 8-byte load. */
+static __always_inline void capture_hello(struct __sk_buff *skb, struct traffic_event_t *event, __u32 off) {
+    if (!off || off >= skb->len || event->direction != 'o')
+        return;
+    if (event->dport != HTTPS_PORT && event->dport != HTTP_PORT)
+        return;
+
+    __u8 head[8];
+    if (skb->len - off < sizeof(head) || bpf_skb_load_bytes(skb, off, head, sizeof(head)) < 0)
+        return;
+    /* TLS handshake record holding a ClientHello */
+    bool tls = head[0] == 0x16 && head[1] == 0x03 && head[5] == 0x01;
+    bool http = (head[0] == 'G' && head[1] == 'E' && head[2] == 'T' && head[3] == ' ') ||
+                (head[0] == 'P' && (head[1] == 'O' || head[1] == 'U' || head[1] == 'A')) ||
+                (head[0] == 'H' && head[1] == 'E' && head[2] == 'A' && head[3] == 'D') ||
+                (head[0] == 'D' && head[1] == 'E' && head[2] == 'L') ||
+                (head[0] == 'O' && head[1] == 'P' && head[2] == 'T');
+    if (!tls && !http)
+        return;
+
+    __u32 len = load_len(skb->len - off, HELLO_MAX_PAYLOAD);
+    struct hello_record_t *rec = bpf_ringbuf_reserve(&hello_ring, sizeof(*rec), 0);
+    if (!rec)
+        return;
+    if (bpf_skb_load_bytes(skb, off, rec->data, len) < 0) {
+        bpf_ringbuf_discard(rec, 0);
+        return;
+    }
+    __builtin_memcpy(&rec->event, event, sizeof(*event));
+    rec->len = len;
+    bpf_ringbuf_submit(rec, 0);
+}
+
+/* cgroup_skb: data starts at the IP header and skb->family is set. Returns 1
+ * when a deny or limit rule drops the packet. */
+static __always_inline int parse_packet(struct __sk_buff *skb, bool is_ingress) {
+    void *data = (void *)(long)skb->data;
+    void *data_end = (void *)(long)skb->data_end;
+
+    struct traffic_event_t event = {};
+    void *l4 = 0;
+    if (parse_l3(data, data_end, skb->family, &event, &l4) < 0) {
+        count(COUNTER_SHORT_PACKET);
+        return 0;
+    }
+    capture_dns_skb(skb, dns_payload_offset(&event, data, l4, data_end));
+
+    event.direction = is_ingress ? 'i' : 'o';
+    event.pkttype = skb->pkt_type;
+    event.bytes = skb->len;
+    event.ifindex = skb->ifindex;
+    event.attach_id = attach_id;
+    add_socket_info(skb, &event);
+    capture_hello(skb, &event, tcp_payload_offset(&event, data, l4, data_end));
+
+    bool drop = denied(&event) || over_limit(&event);
+    submit_event(&event);
+    return drop;
+}
+
+/* tc: data starts at the Ethernet header; bytes are reported from L3 on so
+ * they compare with the cgroup hooks. Returns 1 when a deny or limit rule
+ * drops the packet. */
+static __always_inline int parse_tc_packet(struct __sk_buff *skb, bool is_ingress) {
+    void *data = (void *)(long)skb->data;
+    void *data_end = (void *)(long)skb->data_end;
+    void *l3 = data;
+
+    __u32 family = parse_eth(data, data_end, &l3);
+    if (!family)
+        return 0;
+
+    struct traffic_event_t event = {};
+    void *l4 = 0;
+    if (parse_l3(l3, data_end, family, &event, &l4) < 0) {
+        count(COUNTER_SHORT_PACKET);
+        return 0;
+    }
+    capture_dns_skb(skb, dns_payload_offset(&event, data, l4, data_end));
+
+    event.direction = is_ingress ? 'i' : 'o';
+    event.pkttype = skb->pkt_type;
+    event.bytes = skb->len - (l3 - data);
+    event.ifindex = skb->ifindex;
+    event.attach_id = attach_id;
+    add_socket_info(skb, &event);
+    capture_hello(skb, &event, tcp_payload_offset(&event, data, l4, data_end));
+
+    bool drop = denied(&event) || over_limit(&event);
+    submit_event(&event);
+    return drop;
 }
 
 SEC("cgroup_skb/ingress")
 int monitor_ingress(struct __sk_buff *skb) {
-    parse_packet(skb, true);
-    return 1;
+    return parse_packet(skb, true) ? 0 : 1;
 }
 
 SEC("cgroup_skb/egress")
 int monitor_egress(struct __sk_buff *skb) {
-    parse_packet(skb, false);
+    return parse_packet(skb, false) ? 0 : 1;
+}
+
+SEC("tc")
+int tc_ingress(struct __sk_buff *skb) {
+    return parse_tc_packet(skb, true) ? TC_ACT_SHOT : TC_ACT_UNSPEC;
+}
+
+SEC("tc")
+int tc_egress(struct __sk_buff *skb) {
+    return parse_tc_packet(skb, false) ? TC_ACT_SHOT : TC_ACT_UNSPEC;
+}
+
+/* XDP sees frames before an skb exists: no socket, no pkt_type, and only
+ * the linear part of the frame is counted. */
+SEC("xdp")
+int xdp_ingress(struct xdp_md *ctx) {
+    void *data = (void *)(long)ctx->data;
+    void *data_end = (void *)(long)ctx->data_end;
+    void *l3 = data;
+
+    __u32 family = parse_eth(data, data_end, &l3);
+    if (!family)
+        return XDP_PASS;
+
+    struct traffic_event_t event = {};
+    void *l4 = 0;
+    if (parse_l3(l3, data_end, family, &event, &l4) < 0) {
+        count(COUNTER_SHORT_PACKET);
+        return XDP_PASS;
+    }
+    capture_dns_xdp(ctx, dns_payload_offset(&event, data, l4, data_end), data_end - data);
+
+    event.direction = 'i';
+    event.pkttype = PACKET_HOST;
+    event.bytes = data_end - l3;
+    event.ifindex = ctx->ingress_ifindex;
+    event.attach_id = attach_id;
+
+    bool drop = denied(&event) || over_limit(&event);
+    submit_event(&event);
+    return drop ? XDP_DROP : XDP_PASS;
+}
+
+SEC("cgroup/sock_create")
+int track_sock_create(struct bpf_sock *sk) {
+    __u64 cookie = bpf_get_socket_cookie(sk);
+    struct sock_owner_t owner = {
+        .pid = bpf_get_current_pid_tgid() >> 32,
+    };
+    bpf_get_current_comm(owner.comm, sizeof(owner.comm));
+    bpf_map_update_elem(&sock_owner, &cookie, &owner, BPF_ANY);
     return 1;
 }
 
+/* Fills the task fields of a reserved sock event; called in the context of
+ * the task making the call. */
+static __always_inline void fill_sock_task(struct sock_event_t *ev) {
+    ev->pid = bpf_get_current_pid_tgid() >> 32;
+    ev->uid = bpf_get_current_uid_gid();
+    ev->cgroup_id = bpf_get_current_cgroup_id();
+    ev->ts_ns = bpf_ktime_get_ns();
+    ev->attach_id = attach_id;
+    bpf_get_current_comm(ev->comm, sizeof(ev->comm));
+}
+
+static __always_inline void emit_sock_addr(struct bpf_sock_addr *ctx, __u8 kind, __u32 family) {
+    struct sock_event_t *ev = bpf_ringbuf_reserve(&sock_ring, sizeof(*ev), 0);
+    if (!ev) {
+        count(COUNTER_EMIT_FAILED);
+        return;
+    }
+    __builtin_memset(ev, 0, sizeof(*ev));
+    ev->kind = kind;
+    ev->protocol = ctx->protocol;
+    ev->family = family;
+    ev->port = bpf_ntohs(ctx->user_port);
+    if (family == AF_INET) {
+        __u32 ip4 = ctx->user_ip4;
+        __builtin_memcpy(ev->addr, &ip4, 4);
+    } else {
+        __u32 ip6[4] = { ctx->user_ip6[0], ctx->user_ip6[1], ctx->user_ip6[2], ctx->user_ip6[3] };
+        __builtin_memcpy(ev->addr, ip6, 16);
+    }
+    ev->cookie = bpf_get_socket_cookie(ctx);
+    fill_sock_task(ev);
+    bpf_ringbuf_submit(ev, 0);
+}
+
+static __always_inline void emit_sock_bind(struct bpf_sock *sk, __u32 family) {
+    struct sock_event_t *ev = bpf_ringbuf_reserve(&sock_ring, sizeof(*ev), 0);
+    if (!ev) {
+        count(COUNTER_EMIT_FAILED);
+        return;
+    }
+    __builtin_memset(ev, 0, sizeof(*ev));
+    ev->kind = SOCK_BIND;
+    ev->protocol = sk->protocol;
+    ev->family = family;
+    ev->port = sk->src_port;
+    if (family == AF_INET) {
+        __u32 ip4 = sk->src_ip4;
+        __builtin_memcpy(ev->addr, &ip4, 4);
+    } else {
+        __u32 ip6[4] = { sk->src_ip6[0], sk->src_ip6[1], sk->src_ip6[2], sk->src_ip6[3] };
+        __builtin_memcpy(ev->addr, ip6, 16);
+    }
+    ev->cookie = bpf_get_socket_cookie(sk);
+    fill_sock_task(ev);
+    bpf_ringbuf_submit(ev, 0);
+}
+
+/* The sock_addr and post_bind hooks only observe: they always let the call
+ * through. */
+SEC("cgroup/connect4")
+int trace_connect4(struct bpf_sock_addr *ctx) {
+    emit_sock_addr(ctx, SOCK_CONNECT, AF_INET);
+    return 1;
+}
+
+SEC("cgroup/connect6")
+int trace_connect6(struct bpf_sock_addr *ctx) {
+    emit_sock_addr(ctx, SOCK_CONNECT, AF_INET6);
+    return 1;
+}
+
+SEC("cgroup/sendmsg4")
+int trace_sendmsg4(struct bpf_sock_addr *ctx) {
+    emit_sock_addr(ctx, SOCK_SENDMSG, AF_INET);
+    return 1;
+}
+
+SEC("cgroup/sendmsg6")
+int trace_sendmsg6(struct bpf_sock_addr *ctx) {
+    emit_sock_addr(ctx, SOCK_SENDMSG, AF_INET6);
+    return 1;
+}
+
+SEC("cgroup/post_bind4")
+int trace_bind4(struct bpf_sock *sk) {
+    emit_sock_bind(sk, AF_INET);
+    return 1;
+}
+
+SEC("cgroup/post_bind6")
+int trace_bind6(struct bpf_sock *sk) {
+    emit_sock_bind(sk, AF_INET6);
+    return 1;
+}
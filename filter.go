@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -14,18 +15,24 @@ func (m *model) ipMatchesFilter(ipStr, filterStr string) bool {
 		return true
 	}
 
-	if ipStr == filterStr {
-		return true
-	}
+	for _, item := range strings.Split(filterStr, ",") {
+		if ip := net.ParseIP(item); ip != nil {
+			if ip.Equal(net.ParseIP(ipStr)) {
+				return true
+			}
+			continue
+		}
 
-	if strings.Contains(ipStr, filterStr) {
-		return true
-	}
+		_, cidrNet, err := net.ParseCIDR(item)
+		if err == nil {
+			ip := net.ParseIP(ipStr)
+			if ip != nil && cidrNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
 
-	_, cidrNet, err := net.ParseCIDR(filterStr)
-	if err == nil {
-		ip := net.ParseIP(ipStr)
-		if ip != nil && cidrNet.Contains(ip) {
+		if strings.Contains(ipStr, item) {
 			return true
 		}
 	}
@@ -33,6 +40,20 @@ func (m *model) ipMatchesFilter(ipStr, filterStr string) bool {
 	return false
 }
 
+func portMatchesFilter(port uint16, filterStr string) bool {
+	if lo, hi, ok := parsePortRange(filterStr); ok {
+		return port >= lo && port <= hi
+	}
+	return strconv.Itoa(int(port)) == filterStr
+}
+
+func protoMatchesFilter(proto uint8, filterStr string) bool {
+	if protos, ok := parseProtocols(filterStr); ok {
+		return slices.Contains(protos, proto)
+	}
+	return strings.EqualFold(protoToString(proto), filterStr)
+}
+
 func (m *model) getIPString(ipv4 uint32, ipv6 [16]uint8) string {
 	if ipv4 != 0 {
 		return fmt.Sprintf("%d.%d.%d.%d",
@@ -52,6 +73,7 @@ func (m *model) applyFilter() tea.Cmd {
 	if filterText == "" {
 		m.filter.rawMode = rawFilter{}
 		m.filter.aggMode = aggFilter{}
+		m.clearKernelFilter()
 		m.updateViewportContent()
 		return tea.Printf("Filter cleared")
 	}
@@ -94,16 +116,59 @@ func (m *model) applyRawFilter(filterText string) tea.Cmd {
 				m.filter.rawMode.dstPort = value
 			case "dir", "direction":
 				m.filter.rawMode.direction = value
+			case "cg", "cgroup":
+				m.filter.rawMode.cgroup = value
+			case "proc", "process":
+				m.filter.rawMode.process = value
+			case "sni", "host":
+				m.filter.rawMode.sni = value
 			}
 		}
 	}
+	m.pushKernelFilter()
 	m.updateRawView()
 	return nil
 }
 
+// pushKernelFilter hands whatever part of the raw filter the eBPF programs
+// can evaluate to the source. Userspace still applies the whole filter, so a
+// partial push only saves work. Packets filtered in the kernel are missing
+// from every view, so this is only done while the raw view is filtered in
+// raw mode, and the header says so.
+func (m *model) pushKernelFilter() {
+	m.filter.where = filterUserspace
+	kf, ok := m.source.(KernelFilter)
+	if !ok {
+		return
+	}
+
+	f, complete := buildKernelFilter(m.filter.rawMode)
+	if f.empty() || m.currentView != "raw" || kf.CaptureMode() != captureModeRaw {
+		m.clearKernelFilter()
+		return
+	}
+	if err := kf.SetFilter(f); err != nil {
+		m.setMessage(fmt.Sprintf("kernel filter: %v", err), true)
+		return
+	}
+	m.filter.where = filterKernel
+	if !complete {
+		m.filter.where = filterKernelPartial
+	}
+}
+
+func (m *model) clearKernelFilter() {
+	m.filter.where = filterUserspace
+	if kf, ok := m.source.(KernelFilter); ok {
+		if err := kf.ClearFilter(); err != nil {
+			m.setMessage(fmt.Sprintf("kernel filter: %v", err), true)
+		}
+	}
+}
+
 func (m *model) applyAggFilter(filterText string) tea.Cmd {
 	parts := strings.Split(filterText, " ")
-	m.filter.aggMode = aggFilter{}
+	var f aggFilter
 
 	for _, part := range parts {
 		if strings.Contains(part, "=") {
@@ -117,20 +182,31 @@ func (m *model) applyAggFilter(filterText string) tea.Cmd {
 
 			switch key {
 			case "proto", "protocol":
-				m.filter.aggMode.protocol = value
+				f.protocol = value
 			case "ip":
-				m.filter.aggMode.ip = value
+				f.ip = value
 			case "port":
-				m.filter.aggMode.port = value
+				f.port = value
 			case "minbytes":
-				m.filter.aggMode.minBytes = value
+				f.minBytes = value
 			case "maxbytes":
-				m.filter.aggMode.maxBytes = value
-
+				f.maxBytes = value
+			case "proc", "process":
+				f.process = value
+			case "state":
+				f.state = value
+			case "sni", "host":
+				f.sni = value
 			}
 		}
 	}
-	m.updateAggView()
+	// Rows grouped by address carry neither a process nor a state.
+	if m.currentView == "agg" && !m.groupByProcess && (f.process != "" || f.state != "") {
+		m.setMessage("proc= and state= don't apply to the address rows: press g to group by process, or tab to the conn view", true)
+		return nil
+	}
+	m.filter.aggMode = f
+	m.updateViewportContent()
 	return nil
 }
 
@@ -138,8 +214,7 @@ func (m *model) matchesRawFilter(event StructEvent) bool {
 	f := m.filter.rawMode
 
 	if f.protocol != "" {
-		protoStr := protoToString(event.key.Protocol)
-		if !strings.EqualFold(protoStr, f.protocol) {
+		if !protoMatchesFilter(event.key.Protocol, f.protocol) {
 			return false
 		}
 	}
@@ -158,23 +233,40 @@ func (m *model) matchesRawFilter(event StructEvent) bool {
 		}
 	}
 
+	sport, dport := l4Ports(event.key)
 	if f.srcPort != "" {
-		portStr := strconv.Itoa(int(event.key.Sport))
-		if portStr != f.srcPort {
+		if !portMatchesFilter(sport, f.srcPort) {
 			return false
 		}
 	}
 
 	if f.dstPort != "" {
-		portStr := strconv.Itoa(int(event.key.Dport))
-		if portStr != f.dstPort {
+		if !portMatchesFilter(dport, f.dstPort) {
 			return false
 		}
 	}
 
 	if f.direction != "" {
-		dirStr := directionToString(event.key.Direction)
-		if !strings.EqualFold(dirStr, f.direction) {
+		dir, ok := parseDirection(f.direction)
+		if !ok || dir != event.key.Direction {
+			return false
+		}
+	}
+
+	if f.cgroup != "" {
+		if !strings.EqualFold(getAttachLabel(event.key.AttachID), f.cgroup) {
+			return false
+		}
+	}
+
+	if f.process != "" {
+		if !processMatchesFilter(processOf(event), f.process) {
+			return false
+		}
+	}
+
+	if f.sni != "" {
+		if !sniMatchesFilter(flowHostOf(event), f.sni) {
 			return false
 		}
 	}
@@ -200,8 +292,7 @@ func (m *model) matchesAggFilter(key aggKey, val aggVal) bool {
 	f := m.filter.aggMode
 
 	if f.protocol != "" {
-		protoStr := protoToString(key.Protocol)
-		if !strings.EqualFold(protoStr, f.protocol) {
+		if !protoMatchesFilter(key.Protocol, f.protocol) {
 			return false
 		}
 	}
@@ -214,8 +305,15 @@ func (m *model) matchesAggFilter(key aggKey, val aggVal) bool {
 	}
 
 	if f.port != "" {
-		portStr := strconv.Itoa(int(key.Port))
-		if portStr != f.port {
+		if isICMP(key.Protocol) || !portMatchesFilter(key.Port, f.port) {
+			return false
+		}
+	}
+
+	if f.sni != "" {
+		if key.Protocol != 6 || !slices.ContainsFunc(endpointHostsFor(key.IP, key.Port), func(name string) bool {
+			return sniMatchesFilter(name, f.sni)
+		}) {
 			return false
 		}
 	}
@@ -250,3 +348,65 @@ func (m *model) filterAggResults(results map[aggKey]aggVal) map[aggKey]aggVal {
 	}
 	return filtered
 }
+
+// matchesConnFilter applies the ip, port, state and byte filters of the agg
+// view to a connection; ip and port match either end.
+func (m *model) matchesConnFilter(key connKey, c *connEntry) bool {
+	f := m.filter.aggMode
+	if !m.filter.active || (f == aggFilter{}) {
+		return true
+	}
+	if f.ip != "" && !m.ipMatchesFilter(bytesToIP(key.Local).String(), f.ip) &&
+		!m.ipMatchesFilter(bytesToIP(key.Remote).String(), f.ip) {
+		return false
+	}
+	if f.port != "" && !portMatchesFilter(key.LocalPort, f.port) && !portMatchesFilter(key.RemotePort, f.port) {
+		return false
+	}
+	if f.state != "" && !strings.EqualFold(c.State, f.state) {
+		return false
+	}
+	if f.sni != "" && !sniMatchesFilter(flowHostFor(key), f.sni) {
+		return false
+	}
+	total := c.BytesIn + c.BytesOut
+	if minBytes, err := strconv.ParseUint(f.minBytes, 10, 64); err == nil && total < minBytes {
+		return false
+	}
+	if maxBytes, err := strconv.ParseUint(f.maxBytes, 10, 64); err == nil && total > maxBytes {
+		return false
+	}
+	return true
+}
+
+func processMatchesFilter(p procKey, filterStr string) bool {
+	return strings.EqualFold(p.Comm, filterStr) || strconv.Itoa(int(p.Pid)) == filterStr
+}
+
+func (m *model) filterProcResults(results map[procKey]aggVal) map[procKey]aggVal {
+	f := m.filter.aggMode
+	if !m.filter.active || (f == aggFilter{}) {
+		return results
+	}
+
+	filtered := make(map[procKey]aggVal)
+	for key, val := range results {
+		if f.process != "" && !processMatchesFilter(key, f.process) {
+			continue
+		}
+		if f.minBytes != "" {
+			minBytes, err := strconv.ParseUint(f.minBytes, 10, 64)
+			if err == nil && val.TotalBytes < minBytes {
+				continue
+			}
+		}
+		if f.maxBytes != "" {
+			maxBytes, err := strconv.ParseUint(f.maxBytes, 10, 64)
+			if err == nil && val.TotalBytes > maxBytes {
+				continue
+			}
+		}
+		filtered[key] = val
+	}
+	return filtered
+}
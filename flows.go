@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+const defaultPollInterval = time.Second
+
+type flowVal struct {
+	Bytes   uint64
+	Packets uint64
+}
+
+// pollFlows periodically walks the per-CPU flow_stats map and emits one event
+// per flow carrying the bytes and packets seen since the previous poll. When a
+// reload hands over a new generation, the old map is polled one last time
+// before switching, so its counts are not lost.
+func (s *bpfSource) pollFlows(gen *generation) {
+	defer close(s.sink.events)
+
+	interval := s.opts.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	prev := make(map[string]flowVal)
+	for {
+		var next *generation
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case next = <-s.handover:
+		}
+
+		now := uint64(time.Now().UnixNano())
+		cur := make(map[string]flowVal, len(prev))
+
+		var key []byte
+		var perCPU []flowVal
+		var bpfEvent RawEvent
+		iter := gen.maps()[bpfMapFlowStats].Iterate()
+		for iter.Next(&key, &perCPU) {
+			var total flowVal
+			for _, v := range perCPU {
+				total.Bytes += v.Bytes
+				total.Packets += v.Packets
+			}
+			id := string(key)
+			cur[id] = total
+
+			delta := total
+			if last, ok := prev[id]; ok && last.Packets <= total.Packets && last.Bytes <= total.Bytes {
+				delta.Bytes -= last.Bytes
+				delta.Packets -= last.Packets
+			}
+			if delta.Packets == 0 {
+				continue
+			}
+
+			if err := decodeRawEvent(key, &bpfEvent); err != nil {
+				s.parseErrors.Add(1)
+				continue
+			}
+			s.received.Add(1)
+
+			event := bpfEvent.structEvent(now)
+			event.val = Stats{Bytes: delta.Bytes, Packets: delta.Packets}
+			s.sink.add(event)
+		}
+		if err := iter.Err(); err != nil {
+			log.Printf("Error iterating %s: %v", bpfMapFlowStats, err)
+		}
+		s.sink.flush()
+		prev = cur
+
+		if next != nil {
+			gen.Close()
+			gen, prev = next, make(map[string]flowVal)
+		}
+	}
+}
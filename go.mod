@@ -2,23 +2,26 @@ module ionet
 
 go 1.24.0
 
-require github.com/cilium/ebpf v0.18.0
+require (
+	github.com/cilium/ebpf v0.18.0
+	github.com/likexian/whois v1.15.6
+	golang.org/x/net v0.36.0
+	golang.org/x/sys v0.32.0
+)
 
 require (
 	github.com/atotto/clipboard v0.1.4 // indirect
 	github.com/likexian/gokit v0.25.15 // indirect
-	github.com/likexian/whois v1.15.6 // indirect
 	github.com/likexian/whois-parser v1.24.20 // indirect
-	golang.org/x/net v0.36.0 // indirect
 )
 
 require (
 	github.com/aymanbagabas/go-osc52/v2 v2.0.1 // indirect
-	github.com/charmbracelet/bubbles v0.21.0 // indirect
-	github.com/charmbracelet/bubbletea v1.3.5 // indirect
+	github.com/charmbracelet/bubbles v0.21.0
+	github.com/charmbracelet/bubbletea v1.3.5
 	github.com/charmbracelet/colorprofile v0.2.3-0.20250311203215-f60798e515dc // indirect
-	github.com/charmbracelet/lipgloss v1.1.0 // indirect
-	github.com/charmbracelet/x/ansi v0.8.0 // indirect
+	github.com/charmbracelet/lipgloss v1.1.0
+	github.com/charmbracelet/x/ansi v0.8.0
 	github.com/charmbracelet/x/cellbuf v0.0.13-0.20250311204145-2c3ea96c31dd // indirect
 	github.com/charmbracelet/x/term v0.2.1 // indirect
 	github.com/erikgeiser/coninput v0.0.0-20211004153227-1c3628e74d0f // indirect
@@ -34,6 +37,5 @@ require (
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
 	golang.org/x/sync v0.13.0 // indirect
-	golang.org/x/sys v0.32.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
 )
@@ -9,7 +9,10 @@ import (
 	"github.com/google/gopacket/layers"
 )
 
-var ipClassCache sync.Map
+var (
+	ipClassCache sync.Map
+	ifaceNames   sync.Map
+)
 
 func parseBytes(b uint64) string {
 
@@ -33,6 +36,58 @@ func protoToString(proto uint8) string {
 	return layers.IPProtocol(proto).String()
 }
 
+// eventProtoString marks fragments next to the protocol name, and shows the
+// flags of TCP segments.
+func eventProtoString(key KeyEvent) string {
+	if key.Flags&eventFragment != 0 {
+		return protoToString(key.Protocol) + " frag"
+	}
+	if key.Protocol == 6 && key.TcpFlags != 0 {
+		return protoToString(key.Protocol) + " " + tcpFlagsString(key.TcpFlags)
+	}
+	return protoToString(key.Protocol)
+}
+
+// tcpFlagsString spells TCP flags one letter each, most telling first.
+func tcpFlagsString(flags uint8) string {
+	var b strings.Builder
+	for _, f := range []struct {
+		flag   uint8
+		letter byte
+	}{
+		{tcpSYN, 'S'}, {tcpFIN, 'F'}, {tcpRST, 'R'}, {tcpPSH, 'P'},
+		{tcpACK, 'A'}, {tcpURG, 'U'}, {tcpECE, 'E'}, {tcpCWR, 'C'},
+	} {
+		if flags&f.flag != 0 {
+			b.WriteByte(f.letter)
+		}
+	}
+	return b.String()
+}
+
+// endpointString formats ip:port, with "-" for the port of packets that
+// carry no transport header.
+func endpointString(ip net.IP, port uint16, flags uint8) string {
+	if flags&eventNoL4 != 0 {
+		return fmt.Sprintf("%s:-", ip)
+	}
+	return fmt.Sprintf("%s:%d", ip, port)
+}
+
+// eventEndpoints formats the source and destination of an event. ICMP
+// shows the message name on the source side and the echo identifier or
+// MTU on the destination side instead of ports.
+func eventEndpoints(key KeyEvent, srcIP, dstIP net.IP) (string, string) {
+	if !isICMP(key.Protocol) || key.Flags&eventNoL4 != 0 {
+		return endpointString(srcIP, key.Sport, key.Flags), endpointString(dstIP, key.Dport, key.Flags)
+	}
+	src := fmt.Sprintf("%s %s", srcIP, icmpString(key.Protocol, key.Sport))
+	if v := icmpValue(key.Protocol, key.Sport); v != "" {
+		return src, fmt.Sprintf("%s %s=%d", dstIP, v, key.Dport)
+	}
+	return src, dstIP.String()
+}
+
 func directionToString(dir byte) string {
 	if dir == 'i' {
 		return DIRECTION_INGRESS
@@ -85,7 +140,14 @@ func classifyIPCached(ip net.IP) string {
 	return result
 }
 
+func registerInterfaceName(index uint32, name string) {
+	ifaceNames.Store(index, name)
+}
+
 func getInterfaceName(index uint32) string {
+	if name, ok := ifaceNames.Load(index); ok {
+		return name.(string)
+	}
 	iface, err := net.InterfaceByIndex(int(index))
 	if err != nil {
 		return "Unknown"
@@ -150,10 +212,15 @@ func getIPsFromEvent(ev StructEvent) (net.IP, net.IP) {
 
 func getIPPort(ev StructEvent) (net.IP, uint16) {
 	srcIP, dstIP := getIPsFromEvent(ev)
+	ip, port := srcIP, ev.key.Sport
 	if ev.key.Direction == 'o' {
-		return dstIP, ev.key.Dport
+		ip, port = dstIP, ev.key.Dport
+	}
+	// ICMP is grouped by type and code, whichever way it goes.
+	if isICMP(ev.key.Protocol) {
+		port = ev.key.Sport
 	}
-	return srcIP, ev.key.Sport
+	return ip, port
 }
 
 func ip16ToBytes(ip net.IP) [16]byte {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ICMP events carry type<<8|code in Sport and, depending on the type, the
+// echo identifier or the reported MTU in Dport (see parse_ports in ioNet.c).
+const (
+	protoICMP   = 1
+	protoICMPv6 = 58
+)
+
+type icmpType struct {
+	name  string
+	codes map[uint8]string
+}
+
+var icmpTypes = map[uint8]icmpType{
+	0: {name: "echo-reply"},
+	3: {name: "dest-unreach", codes: map[uint8]string{
+		0: "net", 1: "host", 2: "protocol", 3: "port", 4: "frag-needed",
+		5: "src-route", 6: "net-unknown", 7: "host-unknown", 9: "net-prohibited",
+		10: "host-prohibited", 13: "admin-prohibited",
+	}},
+	4:  {name: "source-quench"},
+	5:  {name: "redirect", codes: map[uint8]string{0: "net", 1: "host"}},
+	8:  {name: "echo-request"},
+	9:  {name: "router-advert"},
+	10: {name: "router-solicit"},
+	11: {name: "time-exceeded", codes: map[uint8]string{0: "ttl", 1: "reassembly"}},
+	12: {name: "param-problem"},
+	13: {name: "timestamp"},
+	14: {name: "timestamp-reply"},
+}
+
+var icmpv6Types = map[uint8]icmpType{
+	1: {name: "dest-unreach", codes: map[uint8]string{
+		0: "no-route", 1: "admin-prohibited", 2: "beyond-scope", 3: "addr",
+		4: "port", 5: "policy-fail", 6: "reject-route",
+	}},
+	2:   {name: "packet-too-big"},
+	3:   {name: "time-exceeded", codes: map[uint8]string{0: "hop-limit", 1: "reassembly"}},
+	4:   {name: "param-problem"},
+	128: {name: "echo-request"},
+	129: {name: "echo-reply"},
+	130: {name: "mld-query"},
+	131: {name: "mld-report"},
+	132: {name: "mld-done"},
+	133: {name: "router-solicit"},
+	134: {name: "router-advert"},
+	135: {name: "neighbor-solicit"},
+	136: {name: "neighbor-advert"},
+	137: {name: "redirect"},
+	143: {name: "mld2-report"},
+}
+
+func isICMP(proto uint8) bool {
+	return proto == protoICMP || proto == protoICMPv6
+}
+
+// icmpString names an ICMP type and code, e.g. "dest-unreach/port".
+// Unknown values are shown as numbers.
+func icmpString(proto uint8, typeCode uint16) string {
+	typ, code := uint8(typeCode>>8), uint8(typeCode)
+	table := icmpTypes
+	if proto == protoICMPv6 {
+		table = icmpv6Types
+	}
+	t, ok := table[typ]
+	if !ok {
+		return fmt.Sprintf("type-%d/%d", typ, code)
+	}
+	if t.codes == nil {
+		if code == 0 {
+			return t.name
+		}
+		return fmt.Sprintf("%s/%d", t.name, code)
+	}
+	if c, ok := t.codes[code]; ok {
+		return t.name + "/" + c
+	}
+	return fmt.Sprintf("%s/%d", t.name, code)
+}
+
+// icmpValue tells what the kernel stores in Dport for a message: "id" for
+// echo requests and replies, "mtu" for too-big messages, "" otherwise.
+func icmpValue(proto uint8, typeCode uint16) string {
+	typ, code := uint8(typeCode>>8), uint8(typeCode)
+	switch {
+	case proto == protoICMP && (typ == 0 || typ == 8),
+		proto == protoICMPv6 && (typ == 128 || typ == 129):
+		return "id"
+	case proto == protoICMP && typ == 3 && code == 4,
+		proto == protoICMPv6 && typ == 2:
+		return "mtu"
+	}
+	return ""
+}
+
+// icmpPorts mirrors parse_ports in ioNet.c for an ICMP message starting
+// at its type byte.
+func icmpPorts(proto uint8, msg []byte) (sport, dport uint16) {
+	if len(msg) < 8 {
+		return 0, 0
+	}
+	sport = uint16(msg[0])<<8 | uint16(msg[1])
+	switch icmpValue(proto, sport) {
+	case "id":
+		dport = binary.BigEndian.Uint16(msg[4:])
+	case "mtu":
+		if proto == protoICMPv6 {
+			dport = uint16(min(binary.BigEndian.Uint32(msg[4:]), 0xffff))
+		} else {
+			dport = binary.BigEndian.Uint16(msg[6:])
+		}
+	}
+	return sport, dport
+}
+
+// l4Ports returns the ports of an event for port filters; ICMP has none.
+func l4Ports(key KeyEvent) (sport, dport uint16) {
+	if isICMP(key.Protocol) {
+		return 0, 0
+	}
+	return key.Sport, key.Dport
+}
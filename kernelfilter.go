@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+const (
+	bpfMapFilterConfig = "filter_config"
+	bpfMapFilterSrcV4  = "filter_src_v4"
+	bpfMapFilterDstV4  = "filter_dst_v4"
+	bpfMapFilterSrcV6  = "filter_src_v6"
+	bpfMapFilterDstV6  = "filter_dst_v6"
+)
+
+const (
+	filterProto = 1 << iota
+	filterSport
+	filterDport
+	filterSrc
+	filterDst
+	filterDir
+)
+
+// filterConfig mirrors struct filter_config_t in ioNet.c.
+type filterConfig struct {
+	Flags      uint32
+	Direction  uint8
+	_          [3]byte
+	Protocols  [4]uint64
+	SportMin   uint16
+	SportMax   uint16
+	DportMin   uint16
+	DportMax   uint16
+	SampleRate uint32
+	_          [4]byte
+}
+
+type lpmKeyV4 struct {
+	Prefixlen uint32
+	Addr      [4]byte
+}
+
+type lpmKeyV6 struct {
+	Prefixlen uint32
+	Addr      [16]byte
+}
+
+// KernelFilter is implemented by sources that can drop events before they
+// reach userspace. Filters are only pushed down in raw mode: in flows mode
+// the kernel would leave filtered packets out of the flow totals.
+type KernelFilter interface {
+	SetFilter(f kernelFilter) error
+	ClearFilter() error
+	CaptureMode() string
+}
+
+type kernelFilter struct {
+	config  filterConfig
+	srcNets []*net.IPNet
+	dstNets []*net.IPNet
+}
+
+// buildKernelFilter translates the parts of a raw filter the eBPF side can
+// evaluate. complete reports whether nothing is left for userspace.
+func buildKernelFilter(f rawFilter) (kf kernelFilter, complete bool) {
+	complete = true
+
+	if f.protocol != "" {
+		if protos, ok := parseProtocols(f.protocol); ok {
+			kf.config.Flags |= filterProto
+			for _, p := range protos {
+				kf.config.Protocols[p>>6] |= 1 << (p & 63)
+			}
+		} else {
+			complete = false
+		}
+	}
+	if f.srcPort != "" {
+		if lo, hi, ok := parsePortRange(f.srcPort); ok {
+			kf.config.Flags |= filterSport
+			kf.config.SportMin, kf.config.SportMax = lo, hi
+		} else {
+			complete = false
+		}
+	}
+	if f.dstPort != "" {
+		if lo, hi, ok := parsePortRange(f.dstPort); ok {
+			kf.config.Flags |= filterDport
+			kf.config.DportMin, kf.config.DportMax = lo, hi
+		} else {
+			complete = false
+		}
+	}
+	if f.direction != "" {
+		if dir, ok := parseDirection(f.direction); ok {
+			kf.config.Flags |= filterDir
+			kf.config.Direction = dir
+		} else {
+			complete = false
+		}
+	}
+	if f.srcIP != "" {
+		if nets, ok := parseFilterNets(f.srcIP); ok {
+			kf.config.Flags |= filterSrc
+			kf.srcNets = nets
+		} else {
+			complete = false
+		}
+	}
+	if f.dstIP != "" {
+		if nets, ok := parseFilterNets(f.dstIP); ok {
+			kf.config.Flags |= filterDst
+			kf.dstNets = nets
+		} else {
+			complete = false
+		}
+	}
+	if f.cgroup != "" || f.process != "" || f.sni != "" {
+		complete = false
+	}
+	return kf, complete
+}
+
+func (kf kernelFilter) empty() bool {
+	return kf.config.Flags == 0
+}
+
+// parseProtocols accepts a comma-separated list of protocol names (as shown in
+// the PROTO column) or numbers.
+func parseProtocols(value string) ([]uint8, bool) {
+	var protos []uint8
+	for _, item := range strings.Split(value, ",") {
+		if n, err := strconv.ParseUint(item, 10, 8); err == nil {
+			protos = append(protos, uint8(n))
+			continue
+		}
+		found := false
+		for p := 0; p < 256; p++ {
+			if strings.EqualFold(protoToString(uint8(p)), item) {
+				protos = append(protos, uint8(p))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return protos, len(protos) > 0
+}
+
+// parsePortRange accepts "80" or "8000-8999".
+func parsePortRange(value string) (lo, hi uint16, ok bool) {
+	from, to, isRange := strings.Cut(value, "-")
+	if !isRange {
+		to = from
+	}
+	l, err := strconv.ParseUint(from, 10, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	h, err := strconv.ParseUint(to, 10, 16)
+	if err != nil || h < l {
+		return 0, 0, false
+	}
+	return uint16(l), uint16(h), true
+}
+
+func parseDirection(value string) (byte, bool) {
+	switch strings.ToLower(value) {
+	case "i", "in", "ingress", DIRECTION_INGRESS:
+		return 'i', true
+	case "o", "out", "egress", DIRECTION_EGRESS:
+		return 'o', true
+	}
+	return 0, false
+}
+
+// parseFilterNets accepts a comma-separated list of IPs and CIDRs. Partial
+// addresses, which userspace matches as substrings, are rejected.
+func parseFilterNets(value string) ([]*net.IPNet, bool) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(value, ",") {
+		if ip := net.ParseIP(item); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, false
+		}
+		nets = append(nets, n)
+	}
+	return nets, len(nets) > 0
+}
+
+func (s *bpfSource) SetFilter(kf kernelFilter) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if s.gen == nil {
+		return fmt.Errorf("eBPF programs not loaded")
+	}
+	if s.opts.Mode != captureModeRaw {
+		return fmt.Errorf("filters are only pushed to the kernel in %s mode", captureModeRaw)
+	}
+	cfg := kf.config
+	cfg.SampleRate = s.config.SampleRate
+	if err := writeFilter(s.gen.maps(), kf, cfg); err != nil {
+		return err
+	}
+	s.config, s.filter = cfg, kf
+	s.filterVersion++
+	return nil
+}
+
+func (s *bpfSource) CaptureMode() string { return s.Options().Mode }
+
+func (s *bpfSource) ClearFilter() error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if s.gen == nil {
+		return nil
+	}
+	cfg := filterConfig{SampleRate: s.config.SampleRate}
+	if err := writeFilter(s.gen.maps(), kernelFilter{}, cfg); err != nil {
+		return err
+	}
+	s.config, s.filter = cfg, kernelFilter{}
+	s.filterVersion++
+	return nil
+}
+
+// writeFilter stores a filter and its config in a generation's maps.
+// Filtering is disabled while the tries are rewritten so no packet is
+// checked against a half-built set.
+func writeFilter(maps map[string]*ebpf.Map, kf kernelFilter, cfg filterConfig) error {
+	config := maps[bpfMapFilterConfig]
+	if err := config.Put(uint32(0), filterConfig{SampleRate: cfg.SampleRate}); err != nil {
+		return err
+	}
+	for _, name := range []string{bpfMapFilterSrcV4, bpfMapFilterDstV4, bpfMapFilterSrcV6, bpfMapFilterDstV6} {
+		if err := clearTrie(maps[name]); err != nil {
+			return err
+		}
+	}
+	if err := fillTries(maps[bpfMapFilterSrcV4], maps[bpfMapFilterSrcV6], kf.srcNets); err != nil {
+		return err
+	}
+	if err := fillTries(maps[bpfMapFilterDstV4], maps[bpfMapFilterDstV6], kf.dstNets); err != nil {
+		return err
+	}
+	return config.Put(uint32(0), cfg)
+}
+
+// SetSampleRate makes the eBPF programs keep about one packet in rate.
+// Events carry the rate they were sampled at, so aggregates stay scaled
+// correctly across changes.
+func (s *bpfSource) SetSampleRate(rate uint32) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if s.gen == nil {
+		return fmt.Errorf("eBPF programs not loaded")
+	}
+	cfg := s.config
+	cfg.SampleRate = max(rate, 1)
+	if err := s.gen.maps()[bpfMapFilterConfig].Put(uint32(0), cfg); err != nil {
+		return err
+	}
+	s.config = cfg
+	s.filterVersion++
+	return nil
+}
+
+func (s *bpfSource) SampleRate() uint32 {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return max(s.config.SampleRate, 1)
+}
+
+func fillTries(v4, v6 *ebpf.Map, nets []*net.IPNet) error {
+	for _, n := range nets {
+		ones, _ := n.Mask.Size()
+		var err error
+		if ip4 := n.IP.To4(); ip4 != nil && len(n.Mask) == net.IPv4len {
+			key := lpmKeyV4{Prefixlen: uint32(ones)}
+			copy(key.Addr[:], ip4)
+			err = v4.Put(key, uint8(1))
+		} else {
+			key := lpmKeyV6{Prefixlen: uint32(ones)}
+			copy(key.Addr[:], n.IP.To16())
+			err = v6.Put(key, uint8(1))
+		}
+		if err != nil {
+			return fmt.Errorf("filter %s: %w", n, err)
+		}
+	}
+	return nil
+}
+
+func clearTrie(m *ebpf.Map) error {
+	var keys [][]byte
+	var key []byte
+	var val uint8
+	iter := m.Iterate()
+	for iter.Next(&key, &val) {
+		keys = append(keys, append([]byte(nil), key...))
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := m.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import "testing"
+
+func TestBuildKernelFilter(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		filter   rawFilter
+		flags    uint32
+		complete bool
+		check    func(kernelFilter) bool
+	}{
+		{"empty", rawFilter{}, 0, true, nil},
+		{"protocols", rawFilter{protocol: "tcp,udp"}, filterProto, true, func(kf kernelFilter) bool {
+			return kf.config.Protocols[0] == 1<<6|1<<17
+		}},
+		{"icmpv6", rawFilter{protocol: "icmpv6"}, filterProto, true, func(kf kernelFilter) bool {
+			return kf.config.Protocols[0] == 1<<58 && kf.config.Protocols[1] == 0
+		}},
+		{"unknown protocol", rawFilter{protocol: "sctp-ish"}, 0, false, nil},
+		{"port range", rawFilter{dstPort: "8000-8999"}, filterDport, true, func(kf kernelFilter) bool {
+			return kf.config.DportMin == 8000 && kf.config.DportMax == 8999
+		}},
+		{"single port", rawFilter{srcPort: "53"}, filterSport, true, func(kf kernelFilter) bool {
+			return kf.config.SportMin == 53 && kf.config.SportMax == 53
+		}},
+		{"reversed range", rawFilter{srcPort: "9000-80"}, 0, false, nil},
+		{"direction", rawFilter{direction: "in"}, filterDir, true, func(kf kernelFilter) bool {
+			return kf.config.Direction == 'i'
+		}},
+		{"networks", rawFilter{srcIP: "10.0.0.0/8,2001:db8::1", dstIP: "192.168.1.1"}, filterSrc | filterDst, true, func(kf kernelFilter) bool {
+			return len(kf.srcNets) == 2 && len(kf.dstNets) == 1
+		}},
+		{"partial address", rawFilter{srcIP: "10.0"}, 0, false, nil},
+		{"userspace only terms", rawFilter{protocol: "tcp", process: "curl"}, filterProto, false, nil},
+		{"sni", rawFilter{sni: "example.com"}, 0, false, nil},
+	} {
+		kf, complete := buildKernelFilter(tt.filter)
+		if kf.config.Flags != tt.flags || complete != tt.complete {
+			t.Errorf("%s: flags %#x complete %v, want %#x %v", tt.name, kf.config.Flags, complete, tt.flags, tt.complete)
+			continue
+		}
+		if tt.check != nil && !tt.check(kf) {
+			t.Errorf("%s: unexpected filter %+v", tt.name, kf)
+		}
+	}
+}
+
+// fakeKernelFilter records what the model pushes down.
+type fakeKernelFilter struct {
+	*fakeSource
+	mode   string
+	pushed *kernelFilter
+}
+
+func (s *fakeKernelFilter) SetFilter(f kernelFilter) error { s.pushed = &f; return nil }
+func (s *fakeKernelFilter) ClearFilter() error             { s.pushed = nil; return nil }
+func (s *fakeKernelFilter) CaptureMode() string            { return s.mode }
+
+func TestPushKernelFilter(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		mode  string
+		view  string
+		where string
+	}{
+		{"raw view in raw mode", captureModeRaw, "raw", filterKernel},
+		{"flows mode", captureModeFlows, "raw", filterUserspace},
+		{"agg view", captureModeRaw, "agg", filterUserspace},
+	} {
+		src := &fakeKernelFilter{fakeSource: newFakeSource(), mode: tt.mode}
+		m := initialModel(src)
+		m.currentView = tt.view
+		m.filter.rawMode = rawFilter{protocol: "tcp"}
+		m.pushKernelFilter()
+
+		if m.filter.where != tt.where || (src.pushed != nil) != (tt.where != filterUserspace) {
+			t.Errorf("%s: where %q, pushed %v; want %q", tt.name, m.filter.where, src.pushed != nil, tt.where)
+		}
+	}
+}
+
+func TestPushKernelFilterPartial(t *testing.T) {
+	src := &fakeKernelFilter{fakeSource: newFakeSource(), mode: captureModeRaw}
+	m := initialModel(src)
+	m.filter.rawMode = rawFilter{protocol: "udp", process: "dig"}
+	m.pushKernelFilter()
+	if m.filter.where != filterKernelPartial {
+		t.Errorf("where = %q, want %q", m.filter.where, filterKernelPartial)
+	}
+
+	m.clearKernelFilter()
+	if src.pushed != nil || m.filter.where != filterUserspace {
+		t.Errorf("filter left in the kernel after clear: %+v", src.pushed)
+	}
+}
+
+func TestToggleViewMovesKernelFilter(t *testing.T) {
+	src := &fakeKernelFilter{fakeSource: newFakeSource(), mode: captureModeRaw}
+	m := initialModel(src)
+	m.filter.active = true
+	m.filter.rawMode = rawFilter{protocol: "tcp"}
+	m.pushKernelFilter()
+
+	m.toggleView()
+	if src.pushed != nil || m.filter.where != filterUserspace {
+		t.Errorf("%s view: filter left in the kernel: %+v", m.currentView, src.pushed)
+	}
+	for m.currentView != "raw" {
+		m.toggleView()
+	}
+	if src.pushed == nil || m.filter.where != filterKernel {
+		t.Errorf("back in the raw view: where %q, pushed %v", m.filter.where, src.pushed != nil)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"log"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cilium/ebpf"
@@ -13,36 +18,62 @@ import (
 )
 
 type RawEvent struct {
-	Protocol  uint8
-	Direction byte
-	Saddr     uint32
-	Daddr     uint32
-	SaddrV6   [16]uint8
-	DaddrV6   [16]uint8
-	Sport     uint16
-	Dport     uint16
-	Ifindex   uint32
-	Family    uint32
-	Pkttype   uint32
-	Bytes     uint64
+	Protocol   uint8
+	Direction  byte
+	Saddr      uint32
+	Daddr      uint32
+	SaddrV6    [16]uint8
+	DaddrV6    [16]uint8
+	Sport      uint16
+	Dport      uint16
+	Ifindex    uint32
+	Family     uint32
+	Pkttype    uint32
+	Bytes      uint64
+	AttachID   uint32
+	Uid        uint32
+	CgroupID   uint64
+	Cookie     uint64
+	Pid        uint32
+	Comm       [16]byte
+	SampleRate uint32
+	TsNs       uint64
+	Flags      uint8
+	TcpFlags   uint8
+	Seq        uint32
+	Ack        uint32
+	Window     uint16
 }
 
 type KeyEvent struct {
-	Protocol  uint8
-	Direction byte
-	Saddr     uint32
-	Daddr     uint32
-	SaddrV6   [16]uint8
-	DaddrV6   [16]uint8
-	Sport     uint16
-	Dport     uint16
-	Ifindex   uint32
-	Family    uint32
-	Pkttype   uint32
+	Protocol   uint8
+	Direction  byte
+	Saddr      uint32
+	Daddr      uint32
+	SaddrV6    [16]uint8
+	DaddrV6    [16]uint8
+	Sport      uint16
+	Dport      uint16
+	Ifindex    uint32
+	Family     uint32
+	Pkttype    uint32
+	AttachID   uint32
+	Uid        uint32
+	CgroupID   uint64
+	Cookie     uint64
+	Pid        uint32
+	Comm       [16]byte
+	SampleRate uint32
+	Flags      uint8
+	TcpFlags   uint8
+	Seq        uint32
+	Ack        uint32
+	Window     uint16
 }
 
 type Stats struct {
-	Bytes uint64
+	Bytes   uint64
+	Packets uint64
 }
 
 type StructEvent struct {
@@ -51,116 +82,684 @@ type StructEvent struct {
 	Timestamp uint64
 }
 
+var rawEventSize = binary.Size(RawEvent{})
+
+// RawEvent.Flags, see EVENT_* in ioNet.c.
+const (
+	eventFragment = 1 << iota // the packet is an IP fragment
+	eventNoL4                 // ports unknown: a non-first fragment or an overlong IPv6 header chain
+)
+
+// RawEvent.TcpFlags, as in the TCP header.
+const (
+	tcpFIN = 1 << iota
+	tcpSYN
+	tcpRST
+	tcpPSH
+	tcpACK
+	tcpURG
+	tcpECE
+	tcpCWR
+)
+
+func (e RawEvent) structEvent(timestamp uint64) StructEvent {
+	return StructEvent{
+		key: KeyEvent{
+			Protocol:   e.Protocol,
+			Direction:  e.Direction,
+			Saddr:      e.Saddr,
+			Daddr:      e.Daddr,
+			SaddrV6:    e.SaddrV6,
+			DaddrV6:    e.DaddrV6,
+			Sport:      e.Sport,
+			Dport:      e.Dport,
+			Ifindex:    e.Ifindex,
+			Family:     e.Family,
+			Pkttype:    e.Pkttype,
+			AttachID:   e.AttachID,
+			Uid:        e.Uid,
+			CgroupID:   e.CgroupID,
+			Cookie:     e.Cookie,
+			Pid:        e.Pid,
+			Comm:       e.Comm,
+			SampleRate: e.SampleRate,
+			Flags:      e.Flags,
+			TcpFlags:   e.TcpFlags,
+			Seq:        e.Seq,
+			Ack:        e.Ack,
+			Window:     e.Window,
+		},
+		val: Stats{
+			Bytes:   e.Bytes,
+			Packets: 1,
+		},
+		Timestamp: timestamp,
+	}
+}
+
+func rawEventFrom(ev StructEvent) RawEvent {
+	return RawEvent{
+		Protocol:   ev.key.Protocol,
+		Direction:  ev.key.Direction,
+		Saddr:      ev.key.Saddr,
+		Daddr:      ev.key.Daddr,
+		SaddrV6:    ev.key.SaddrV6,
+		DaddrV6:    ev.key.DaddrV6,
+		Sport:      ev.key.Sport,
+		Dport:      ev.key.Dport,
+		Ifindex:    ev.key.Ifindex,
+		Family:     ev.key.Family,
+		Pkttype:    ev.key.Pkttype,
+		Bytes:      ev.val.Bytes,
+		AttachID:   ev.key.AttachID,
+		Uid:        ev.key.Uid,
+		CgroupID:   ev.key.CgroupID,
+		Cookie:     ev.key.Cookie,
+		Pid:        ev.key.Pid,
+		Comm:       ev.key.Comm,
+		SampleRate: ev.key.SampleRate,
+		Flags:      ev.key.Flags,
+		TcpFlags:   ev.key.TcpFlags,
+		Seq:        ev.key.Seq,
+		Ack:        ev.key.Ack,
+		Window:     ev.key.Window,
+	}
+}
+
 const (
-	bpfFilePath          = "ioNet.o"
 	bpfIngressCgroupProg = "monitor_ingress"
 	bpfEgressCgroupProg  = "monitor_egress"
+	bpfSockCreateProg    = "track_sock_create"
+	bpfConnect4Prog      = "trace_connect4"
+	bpfConnect6Prog      = "trace_connect6"
+	bpfSendmsg4Prog      = "trace_sendmsg4"
+	bpfSendmsg6Prog      = "trace_sendmsg6"
+	bpfBind4Prog         = "trace_bind4"
+	bpfBind6Prog         = "trace_bind6"
+	bpfTCIngressProg     = "tc_ingress"
+	bpfTCEgressProg      = "tc_egress"
+	bpfXDPIngressProg    = "xdp_ingress"
 	bpfMapTraffic        = "traffic_ring"
-	cgroupPath           = "/sys/fs/cgroup/"
+	bpfMapDNS            = "dns_ring"
+	bpfMapHello          = "hello_ring"
+	bpfMapSock           = "sock_ring"
+	bpfMapSockOwner      = "sock_owner"
+	bpfMapFlowStats      = "flow_stats"
+	bpfMapCounters       = "counters"
+	bpfVarAttachID       = "attach_id"
+	bpfVarCaptureMode    = "capture_mode"
+)
+
+const (
+	captureModeFlows = "flows"
+	captureModeRaw   = "raw"
 )
 
-func LoadAndAttach() (chan StructEvent, *ebpf.Collection, []link.Link, chan error) {
+var captureModes = map[string]uint8{
+	captureModeFlows: 0,
+	captureModeRaw:   1,
+}
 
-	var links []link.Link
+const (
+	attachCgroup = "cgroup"
+	attachTC     = "tc"
+	attachXDP    = "xdp"
+)
+
+// attachPoint is one place the programs get attached: a cgroup in cgroup
+// mode, a network device in tc and xdp modes.
+type attachPoint struct {
+	Label  string
+	Cgroup string
+	Iface  *net.Interface
+}
+
+type loaderOptions struct {
+	ObjectPath   string
+	ConfigPath   string
+	Attach       string
+	Targets      []cgroupTarget
+	Interfaces   []string
+	Mode         string
+	PollInterval time.Duration
+	RingSize     int
+	SampleRate   uint32
+	DropPolicy   string
+	BlockTimeout time.Duration
+	QueueBudget  int
+	Enforce      bool
+	Limits       []limitSpec // applied at start, then managed as rules
+}
+
+// generation is one loaded set of collections with their shared maps, ring
+// reader and links. A reload replaces the whole generation.
+type generation struct {
+	attach string
+	points []attachPoint
+	colls  []*ebpf.Collection
+	links  [][]link.Link // per point, in attachHooks order
+	rd     *ringbuf.Reader
+	dns    *ringbuf.Reader
+	hello  *ringbuf.Reader
+	sock   *ringbuf.Reader
+}
+
+func (g *generation) maps() map[string]*ebpf.Map { return g.colls[0].Maps }
+
+// readNames starts the readers of the DNS and hello rings, which stop when
+// the generation is closed.
+func (g *generation) readNames() {
+	go readDNS(g.dns)
+	go readHello(g.hello)
+}
+
+func (g *generation) Close() {
+	if g.rd != nil {
+		g.rd.Close()
+	}
+	if g.dns != nil {
+		g.dns.Close()
+	}
+	if g.hello != nil {
+		g.hello.Close()
+	}
+	if g.sock != nil {
+		g.sock.Close()
+	}
+	for _, pointLinks := range g.links {
+		for _, l := range pointLinks {
+			l.Close()
+		}
+	}
+	for _, coll := range g.colls {
+		coll.Close()
+	}
+}
+
+type bpfSource struct {
+	opts     loaderOptions
+	sink     *eventSink
+	errs     chan error
+	done     chan struct{}
+	handover chan *generation
+
+	// sockEvents carries the socket calls seen by the cgroup sock_addr and
+	// post_bind programs.
+	sockEvents  chan SockEvent
+	sockDropped atomic.Uint64
 
-	spec, err := ebpf.LoadCollectionSpec(bpfFilePath)
+	// reloadMu serialises Reload and Stop.
+	reloadMu sync.Mutex
+
+	// configMu guards opts and gen, and the filter state written into the
+	// generation's maps.
+	configMu sync.Mutex
+	gen      *generation
+	config   filterConfig
+	filter   kernelFilter
+	// filterVersion counts filter and sample rate changes.
+	filterVersion uint64
+	retired       SourceStats // kernel counters of replaced generations
+	clock         monoClock
+	// deny holds the deny rules, blocks and limits, by id; denyVersion
+	// counts their changes.
+	deny        map[uint32]denyRule
+	nextDenyID  uint32
+	denyVersion uint64
+	retiredDeny map[uint32]denyCounters // counters of replaced generations
+
+	received    atomic.Uint64
+	parseErrors atomic.Uint64
+}
+
+// Indexes into the per-CPU counters map, see ioNet.c.
+const (
+	counterEmitFailed = iota
+	counterShortPacket
+)
+
+func newBpfSource(opts loaderOptions) *bpfSource {
+	done := make(chan struct{})
+	return &bpfSource{
+		opts:     opts,
+		sink:     newEventSink(opts.QueueBudget, done, opts.DropPolicy, opts.BlockTimeout),
+		errs:     make(chan error, 8),
+		done:     done,
+		handover: make(chan *generation),
+
+		sockEvents: make(chan SockEvent, sockQueueLen),
+
+		deny:        make(map[uint32]denyRule),
+		retiredDeny: make(map[uint32]denyCounters),
+	}
+}
+
+func (s *bpfSource) Start() error {
+	if err := checkLoaderOptions(s.opts); err != nil {
+		return err
+	}
+	if err := checkDropPolicy(s.opts.DropPolicy); err != nil {
+		return err
+	}
+	points, err := resolveAttachPoints(s.opts)
 	if err != nil {
-		log.Fatalf("failed to load spec: %v", err)
+		return err
 	}
+	initProcResolver()
 
-	coll, err := ebpf.NewCollection(spec)
+	gen, err := LoadAndAttach(s.opts, points, nil, nil)
 	if err != nil {
-		log.Printf("load collection: %s", err)
+		return err
+	}
+	s.gen = gen
+	gen.readNames()
+	go s.readSock(gen.sock)
+	// Stop is not called when Start fails, so unload the programs here.
+	fail := func(err error) error {
+		s.configMu.Lock()
+		s.gen = nil
+		s.configMu.Unlock()
+		gen.Close()
+		return err
+	}
+	if s.opts.SampleRate > 1 {
+		if err := s.SetSampleRate(s.opts.SampleRate); err != nil {
+			return fail(err)
+		}
+	}
+	if s.opts.Enforce {
+		for _, l := range s.opts.Limits {
+			if _, err := s.Limit(l.Net, l.Port, l.Rate, 0); err != nil {
+				return fail(err)
+			}
+		}
+		go s.expireDenyRules()
 	}
+	if s.opts.Mode == captureModeFlows {
+		go s.pollFlows(gen)
+	} else {
+		go s.readLoop(gen)
+	}
+	return nil
+}
 
-	programs := map[string]*ebpf.Program{
-		bpfIngressCgroupProg: coll.Programs[bpfIngressCgroupProg],
-		bpfEgressCgroupProg:  coll.Programs[bpfEgressCgroupProg],
+func checkLoaderOptions(opts loaderOptions) error {
+	if _, ok := captureModes[opts.Mode]; !ok {
+		return fmt.Errorf("unknown capture mode %q", opts.Mode)
+	}
+	if len(opts.Limits) > 0 && !opts.Enforce {
+		return errors.New("-limit needs -enforce")
 	}
+	if opts.RingSize < 0 || opts.RingSize > maxRingSize {
+		return fmt.Errorf("ring buffer size must be at most %d MiB", maxRingSize>>20)
+	}
+	return nil
+}
 
-	ingressLink, err := link.AttachCgroup(link.CgroupOptions{
-		Path:    cgroupPath,
-		Attach:  ebpf.AttachCGroupInetIngress,
-		Program: programs[bpfIngressCgroupProg],
-	})
-	if err != nil {
-		log.Fatalf("attach ingress: %v", err)
+func (s *bpfSource) Stop() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	if s.gen != nil {
+		s.gen.Close()
 	}
-	links = append(links, ingressLink)
+	return nil
+}
 
-	egressLink, err := link.AttachCgroup(link.CgroupOptions{
-		Path:    cgroupPath,
-		Attach:  ebpf.AttachCGroupInetEgress,
-		Program: programs[bpfEgressCgroupProg],
-	})
-	if err != nil {
-		log.Fatalf("attach egress: %v", err)
+func (s *bpfSource) Events() <-chan EventBatch { return s.sink.events }
+
+func (s *bpfSource) Errors() <-chan error { return s.errs }
+
+func (s *bpfSource) Stats() SourceStats {
+	stats := SourceStats{
+		Received:    s.received.Load(),
+		Dropped:     s.sink.dropped.Load(),
+		ParseErrors: s.parseErrors.Load(),
+	}
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	kernel := s.retired
+	if s.gen != nil {
+		kernel = kernel.add(s.gen.kernelStats())
 	}
-	links = append(links, egressLink)
+	stats.KernelDropped = kernel.KernelDropped
+	stats.ParseErrors += kernel.ParseErrors
+	return stats
+}
 
-	statsMap := coll.Maps[bpfMapTraffic]
-	if statsMap == nil {
-		log.Printf("[%s] map not found", bpfMapTraffic)
+func (g *generation) kernelStats() SourceStats {
+	counters := g.maps()[bpfMapCounters]
+	return SourceStats{
+		KernelDropped: readCounter(counters, counterEmitFailed),
+		ParseErrors:   readCounter(counters, counterShortPacket),
 	}
+}
 
-	rd, err := ringbuf.NewReader(statsMap)
-	if err != nil {
-		log.Fatal(err)
+func (a SourceStats) add(b SourceStats) SourceStats {
+	return SourceStats{
+		Received:      a.Received + b.Received,
+		Dropped:       a.Dropped + b.Dropped,
+		KernelDropped: a.KernelDropped + b.KernelDropped,
+		ParseErrors:   a.ParseErrors + b.ParseErrors,
 	}
+}
 
-	events := make(chan StructEvent, 1<<20)
-	errChan := make(chan error, 8)
+func readCounter(m *ebpf.Map, idx uint32) uint64 {
+	var perCPU []uint64
+	if err := m.Lookup(idx, &perCPU); err != nil {
+		return 0
+	}
+	var total uint64
+	for _, v := range perCPU {
+		total += v
+	}
+	return total
+}
 
-	go func() {
-		defer close(events)
-		defer rd.Close()
-		for {
-			record, err := rd.Read()
+func resolveAttachPoints(opts loaderOptions) ([]attachPoint, error) {
+	var points []attachPoint
+	switch opts.Attach {
+	case attachCgroup, "":
+		targets, err := resolveCgroupTargets(opts.Targets)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			points = append(points, attachPoint{Label: target.Label, Cgroup: target.Path})
+		}
+	case attachTC, attachXDP:
+		if len(opts.Interfaces) == 0 {
+			return nil, fmt.Errorf("%s attach mode needs at least one interface", opts.Attach)
+		}
+		for _, name := range opts.Interfaces {
+			iface, err := net.InterfaceByName(name)
 			if err != nil {
-				if errors.Is(err, ringbuf.ErrClosed) {
-					log.Println("Ring buffer closed, stopping reader")
-					return
+				return nil, fmt.Errorf("interface %s: %w", name, err)
+			}
+			points = append(points, attachPoint{Label: opts.Attach + "/" + iface.Name, Iface: iface})
+		}
+	default:
+		return nil, fmt.Errorf("unknown attach mode %q", opts.Attach)
+	}
+	return points, nil
+}
+
+// same reports whether p and o are the same cgroup or device.
+func (p attachPoint) same(o attachPoint) bool {
+	if p.Iface != nil || o.Iface != nil {
+		return p.Iface != nil && o.Iface != nil && p.Iface.Index == o.Iface.Index
+	}
+	return p.Cgroup == o.Cgroup
+}
+
+var sharedMaps = []string{
+	bpfMapTraffic, bpfMapDNS, bpfMapHello, bpfMapSock, bpfMapSockOwner, bpfMapFlowStats, bpfMapCounters,
+	bpfMapFilterConfig, bpfMapFilterSrcV4, bpfMapFilterDstV4, bpfMapFilterSrcV6, bpfMapFilterDstV6,
+	bpfMapDenyV4, bpfMapDenyV6, bpfMapLimitV4, bpfMapLimitV6, bpfMapLimitState, bpfMapDenyStats,
+}
+
+// ringBufferSize rounds size up to the power-of-two number of pages the
+// kernel requires for a ring buffer.
+func ringBufferSize(size int) uint32 {
+	n := os.Getpagesize()
+	for n < size {
+		n <<= 1
+	}
+	return uint32(n)
+}
+
+// LoadAndAttach loads one collection per attach point, each stamped with
+// the point's attach_id, and attaches them all to a single shared set of
+// maps. Points that prev is already attached to keep their links, which are
+// switched to the new programs in place so they neither miss nor double count
+// packets; the links move to the returned generation. setup, if set, can
+// fill the new maps before any program is attached. On error everything
+// created so far is released and prev keeps running its own programs.
+func LoadAndAttach(opts loaderOptions, points []attachPoint, prev *generation, setup func(maps map[string]*ebpf.Map) error) (gen *generation, err error) {
+	if opts.Attach == "" {
+		opts.Attach = attachCgroup
+	}
+	gen = &generation{attach: opts.Attach, points: points}
+	reused := make([]int, len(points))
+	type swap struct {
+		l   link.Link
+		old *ebpf.Program
+	}
+	var swapped []swap
+	defer func() {
+		if err != nil {
+			for _, sw := range swapped {
+				sw.l.Update(sw.old)
+			}
+			for i, pointLinks := range gen.links {
+				if reused[i] < 0 {
+					for _, l := range pointLinks {
+						l.Close()
+					}
 				}
-				log.Printf("Error reading from ringbuf: %v", err)
-				continue
 			}
+			gen.links = nil
+			gen.Close()
+			gen = nil
+		}
+	}()
 
-			var bpfEvent RawEvent
+	spec, err := loadCollectionSpec(opts.ObjectPath)
+	if err != nil {
+		return gen, fmt.Errorf("load spec: %w", err)
+	}
+	if err := checkEventLayout(spec); err != nil {
+		return gen, err
+	}
+
+	if err := spec.Variables[bpfVarCaptureMode].Set(captureModes[opts.Mode]); err != nil {
+		return gen, fmt.Errorf("set %s: %w", bpfVarCaptureMode, err)
+	}
+	var enforce uint8
+	if opts.Enforce {
+		enforce = 1
+	}
+	if err := spec.Variables[bpfVarEnforce].Set(enforce); err != nil {
+		return gen, fmt.Errorf("set %s: %w", bpfVarEnforce, err)
+	}
+	if opts.Mode == captureModeFlows {
+		spec.Maps[bpfMapTraffic].MaxEntries = uint32(os.Getpagesize())
+	} else if opts.RingSize > 0 {
+		spec.Maps[bpfMapTraffic].MaxEntries = ringBufferSize(opts.RingSize)
+	}
+
+	var replacements map[string]*ebpf.Map
+	for _, point := range points {
+		targetSpec := spec.Copy()
+		if err := targetSpec.Variables[bpfVarAttachID].Set(attachIDFor(point.Label)); err != nil {
+			return gen, fmt.Errorf("set %s: %w", bpfVarAttachID, err)
+		}
 
-			if err := binary.Read(bytes.NewBuffer(record.RawSample),
-				binary.LittleEndian, &bpfEvent); err != nil {
-				log.Printf("Failed to parse event: %v", err)
-				continue
+		coll, err := ebpf.NewCollectionWithOptions(targetSpec, ebpf.CollectionOptions{
+			MapReplacements: replacements,
+		})
+		if err != nil {
+			return gen, classifyLoadError(targetSpec, err)
+		}
+		gen.colls = append(gen.colls, coll)
+
+		if replacements == nil {
+			replacements = make(map[string]*ebpf.Map)
+			for _, name := range sharedMaps {
+				m := coll.Maps[name]
+				if m == nil {
+					return gen, fmt.Errorf("eBPF object has no %s map; rebuild ioNet.o", name)
+				}
+				replacements[name] = m
 			}
+		}
+	}
 
-			event := StructEvent{
-				key: KeyEvent{
-
-					Protocol:  bpfEvent.Protocol,
-					Direction: bpfEvent.Direction,
-					Saddr:     bpfEvent.Saddr,
-					Daddr:     bpfEvent.Daddr,
-					SaddrV6:   bpfEvent.SaddrV6,
-					DaddrV6:   bpfEvent.DaddrV6,
-					Sport:     bpfEvent.Sport,
-					Dport:     bpfEvent.Dport,
-					Ifindex:   bpfEvent.Ifindex,
-					Family:    bpfEvent.Family,
-					Pkttype:   bpfEvent.Pkttype,
-				},
-				val: Stats{
-					Bytes: bpfEvent.Bytes,
-				},
-				Timestamp: uint64(time.Now().Unix()),
+	if setup != nil {
+		if err := setup(replacements); err != nil {
+			return gen, err
+		}
+	}
+
+	for i, point := range points {
+		reused[i] = -1
+		if prev != nil && prev.attach == opts.Attach {
+			reused[i] = slices.IndexFunc(prev.points, point.same)
+		}
+		if reused[i] < 0 {
+			pointLinks, err := attachPrograms(gen.colls[i], opts.Attach, point)
+			gen.links = append(gen.links, pointLinks)
+			if err != nil {
+				return gen, classifyAttachError(point.Label, err)
 			}
-			select {
-			case events <- event:
+			continue
+		}
+
+		pointLinks := prev.links[reused[i]]
+		gen.links = append(gen.links, pointLinks)
+		for j, hook := range attachHooks[opts.Attach] {
+			if err := pointLinks[j].Update(gen.colls[i].Programs[hook.prog]); err != nil {
+				return gen, classifyAttachError(point.Label, fmt.Errorf("update %s: %w", hook.prog, err))
+			}
+			swapped = append(swapped, swap{pointLinks[j], prev.colls[reused[i]].Programs[hook.prog]})
+		}
+	}
+
+	gen.rd, err = ringbuf.NewReader(replacements[bpfMapTraffic])
+	if err != nil {
+		return gen, fmt.Errorf("open %s: %w", bpfMapTraffic, err)
+	}
+	gen.dns, err = ringbuf.NewReader(replacements[bpfMapDNS])
+	if err != nil {
+		return gen, fmt.Errorf("open %s: %w", bpfMapDNS, err)
+	}
+	gen.hello, err = ringbuf.NewReader(replacements[bpfMapHello])
+	if err != nil {
+		return gen, fmt.Errorf("open %s: %w", bpfMapHello, err)
+	}
+	gen.sock, err = ringbuf.NewReader(replacements[bpfMapSock])
+	if err != nil {
+		return gen, fmt.Errorf("open %s: %w", bpfMapSock, err)
+	}
 
+	// The reused links belong to gen now; prev closes only what is left.
+	for _, j := range reused {
+		if j >= 0 {
+			prev.links[j] = nil
+		}
+	}
+	return gen, nil
+}
+
+type attachHook struct {
+	prog   string
+	attach ebpf.AttachType
+}
+
+// attachHooks lists the programs attached at each point, per attach mode.
+var attachHooks = map[string][]attachHook{
+	attachCgroup: {
+		{bpfIngressCgroupProg, ebpf.AttachCGroupInetIngress},
+		{bpfEgressCgroupProg, ebpf.AttachCGroupInetEgress},
+		{bpfSockCreateProg, ebpf.AttachCGroupInetSockCreate},
+		{bpfConnect4Prog, ebpf.AttachCGroupInet4Connect},
+		{bpfConnect6Prog, ebpf.AttachCGroupInet6Connect},
+		{bpfSendmsg4Prog, ebpf.AttachCGroupUDP4Sendmsg},
+		{bpfSendmsg6Prog, ebpf.AttachCGroupUDP6Sendmsg},
+		{bpfBind4Prog, ebpf.AttachCGroupInet4PostBind},
+		{bpfBind6Prog, ebpf.AttachCGroupInet6PostBind},
+	},
+	attachTC: {
+		{bpfTCIngressProg, ebpf.AttachTCXIngress},
+		{bpfTCEgressProg, ebpf.AttachTCXEgress},
+	},
+	attachXDP: {
+		{bpfXDPIngressProg, ebpf.AttachXDP},
+	},
+}
+
+func attachPrograms(coll *ebpf.Collection, mode string, point attachPoint) ([]link.Link, error) {
+	var links []link.Link
+	for _, hook := range attachHooks[mode] {
+		var l link.Link
+		var err error
+		switch mode {
+		case attachTC:
+			l, err = link.AttachTCX(link.TCXOptions{
+				Interface: point.Iface.Index,
+				Program:   coll.Programs[hook.prog],
+				Attach:    hook.attach,
+			})
+			if err != nil {
+				err = fmt.Errorf("%w (tcx needs Linux 6.6 or newer)", err)
+			}
+		case attachXDP:
+			l, err = link.AttachXDP(link.XDPOptions{
+				Program:   coll.Programs[hook.prog],
+				Interface: point.Iface.Index,
+			})
+		default:
+			l, err = link.AttachCgroup(link.CgroupOptions{
+				Path:    point.Cgroup,
+				Attach:  hook.attach,
+				Program: coll.Programs[hook.prog],
+			})
+		}
+		if err != nil {
+			return links, fmt.Errorf("%s: %w", hook.prog, err)
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+// readLoop reads gen's ring buffer, switching to the next generation when a
+// reload hands one over. The old ring is drained first, so no event emitted
+// before the switch is lost.
+func (s *bpfSource) readLoop(gen *generation) {
+	defer close(s.sink.events)
+
+	var record ringbuf.Record
+	var bpfEvent RawEvent
+	// The deadline only fires once the ring is empty. It bounds how long a
+	// partial batch waits on a quiet host and how late a handover is seen.
+	gen.rd.SetDeadline(time.Now().Add(batchFlushInterval))
+	for {
+		err := gen.rd.ReadInto(&record)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			s.sink.flush()
+			select {
+			case next := <-s.handover:
+				gen.Close()
+				gen = next
+			case <-s.done:
+				return
 			default:
-				errChan <- errors.New(ERR_CHAN)
 			}
+			gen.rd.SetDeadline(time.Now().Add(batchFlushInterval))
+			continue
 		}
-	}()
+		if err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				log.Println("Ring buffer closed, stopping reader")
+				return
+			}
+			log.Printf("Error reading from ringbuf: %v", err)
+			continue
+		}
+
+		if err := decodeRawEvent(record.RawSample, &bpfEvent); err != nil {
+			s.parseErrors.Add(1)
+			continue
+		}
+		s.received.Add(1)
 
-	return events, coll, links, errChan
+		s.sink.add(bpfEvent.structEvent(s.clock.wall(bpfEvent.TsNs)))
+	}
 }
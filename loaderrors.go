@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/features"
+	"golang.org/x/sys/unix"
+)
+
+// Errors returned by the loader for the host problems we see most often on
+// new machines. `ionet doctor` checks for each of them up front.
+var (
+	errNoPrivileges = errors.New("insufficient privileges: CAP_BPF (or CAP_SYS_ADMIN) and CAP_NET_ADMIN are required")
+	errNoCgroup2    = errors.New("cgroup v2 is not mounted")
+	errNoBTF        = errors.New("kernel BTF is not available")
+	errNoRingbuf    = errors.New("kernel has no BPF ring buffer support (Linux 5.8 or newer is required)")
+)
+
+// verifierError carries the verifier log of a rejected program.
+type verifierError struct {
+	err error
+	log *ebpf.VerifierError
+}
+
+func (e *verifierError) Error() string {
+	return fmt.Sprintf("the kernel verifier rejected the eBPF programs: %v\n%+v", e.err, e.log)
+}
+
+func (e *verifierError) Unwrap() error { return e.err }
+
+// attachError reports which program could not be attached where.
+type attachError struct {
+	Point string
+	Err   error
+}
+
+func (e *attachError) Error() string {
+	return fmt.Sprintf("attach to %s: %v", e.Point, e.Err)
+}
+
+func (e *attachError) Unwrap() error { return e.Err }
+
+// classifyLoadError maps a failure to load spec to one of the typed errors
+// above, errBTFIncompatible or, via explainLoadError, errNoBTF.
+func classifyLoadError(spec *ebpf.CollectionSpec, err error) error {
+	// Rejected programs also fail with EACCES, so look for a verifier log
+	// before blaming privileges.
+	var ve *ebpf.VerifierError
+	if errors.As(err, &ve) {
+		if relo := unmatchedRelocation(spec); relo != nil {
+			return fmt.Errorf("%w: %v has no match in the kernel BTF; rebuild ioNet.o against this kernel (make -C eBPF_module) and pass it with -bpf-object",
+				errBTFIncompatible, relo)
+		}
+		return &verifierError{err: err, log: ve}
+	}
+	if isPermissionError(err) {
+		return fmt.Errorf("%w: %v", errNoPrivileges, err)
+	}
+	if errors.Is(err, ebpf.ErrNotSupported) && features.HaveMapType(ebpf.RingBuf) != nil {
+		return fmt.Errorf("%w: %v", errNoRingbuf, err)
+	}
+	return explainLoadError(err)
+}
+
+func classifyAttachError(point string, err error) error {
+	if isPermissionError(err) {
+		return fmt.Errorf("%w: %v", errNoPrivileges, &attachError{Point: point, Err: err})
+	}
+	return &attachError{Point: point, Err: err}
+}
+
+func isPermissionError(err error) bool {
+	return errors.Is(err, os.ErrPermission) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES)
+}
@@ -1,30 +1,68 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 func main() {
-	events, coll, links, errChan := LoadAndAttach()
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 
-	defer coll.Close()
-	defer func() {
-		for _, l := range links {
-			l.Close()
+	var source EventSource
+	var err error
+	switch command {
+	case "":
+		source, err = liveCommand(args)
+	case "replay":
+		source, err = replayCommand(args)
+	case "play":
+		source, err = playCommand(args)
+	case "record":
+		if err := recordCommand(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fatal(err)
 		}
-	}()
+		return
+	case "doctor":
+		if err := doctorCommand(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fatal(err)
+		}
+		return
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	runTUI(source)
+}
+
+func runTUI(source EventSource) {
+	if err := source.Start(); err != nil {
+		fatal(err)
+	}
+	defer source.Stop()
+
 	initStyles()
 	initWhois()
-	m := initialModel(events)
+	m := initialModel(source)
 	go func() {
-		if err := <-errChan; err != nil {
-			if err.Error() == ERR_CHAN {
-				m.setMessage("Events channel full, dropping event", true)
-			}
-
+		for err := range source.Errors() {
+			m.setMessage(err.Error(), true)
 		}
 	}()
 
@@ -33,8 +71,23 @@ func main() {
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
 	)
+	if _, ok := source.(Reconfigurable); ok {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for range hup {
+				p.Send(reloadRequestMsg{})
+			}
+		}()
+	}
+
 	if _, err := p.Run(); err != nil {
-		fmt.Println(errorStyle.Render("ERROR: " + err.Error()))
-		os.Exit(1)
+		fatal(err)
 	}
 }
+
+func fatal(err error) {
+	fmt.Println(errorStyle.Render("ERROR: " + err.Error()))
+	os.Exit(1)
+}
@@ -14,6 +14,19 @@ type filter struct {
 	input   textinput.Model
 	rawMode rawFilter
 	aggMode aggFilter
+	where   string
+}
+
+const (
+	filterUserspace     = "userspace"
+	filterKernel        = "kernel"
+	filterKernelPartial = "kernel+userspace"
+)
+
+// palette is the ":" command line for reconfiguring a live capture.
+type palette struct {
+	active bool
+	input  textinput.Model
 }
 
 type rawFilter struct {
@@ -23,6 +36,9 @@ type rawFilter struct {
 	srcPort   string
 	dstPort   string
 	direction string
+	cgroup    string
+	process   string
+	sni       string
 }
 
 type aggFilter struct {
@@ -31,25 +47,66 @@ type aggFilter struct {
 	port     string
 	minBytes string
 	maxBytes string
+	process  string
+	state    string
+	sni      string
 }
 
 type model struct {
 	currentView    string
-	events         chan StructEvent
+	source         EventSource
 	mu             sync.RWMutex
 	rawEvents      []StructEvent
+	procScratch    []procKey
 	aggResults     map[aggKey]aggVal
+	procResults    map[procKey]aggVal
+	conns          *connTracker
+	sockEvents     []SockEvent
+	sockScratch    []SockEvent
+	sockFlows      map[uint64]*sockFlow
+	aggKeys        []aggKey // agg rows as last rendered
+	denyRules      []denyStatus
+	denyRates      map[uint32]*dropMeter // bytes/s let through by limit rules
+	denyIDs        []uint32              // deny rules as last rendered
+	selected       int                   // row cursor of the agg and deny views
+	selectedAgg    aggKey
+	seek           uint64 // playback seeks applied to the data shown
+	groupByProcess bool
+	timeMode       int
 	aggEventsCount int
 	width          int
 	height         int
 	message        string
 	isError        bool
 	filter         filter
+	palette        palette
 	autoScroll     bool
 	showLocal      bool
 	viewport       viewport.Model
 	headerView     viewport.Model
+	drops          dropMeter
+}
+
+// dropMeter turns the source's cumulative drop counter into a per-second rate.
+type dropMeter struct {
+	last    uint64
+	checked time.Time
+	rate    float64
 }
+
+func (d *dropMeter) update(total uint64, now time.Time) {
+	if d.checked.IsZero() {
+		d.last, d.checked = total, now
+		return
+	}
+	elapsed := now.Sub(d.checked)
+	if elapsed < time.Second {
+		return
+	}
+	d.rate = float64(total-d.last) / elapsed.Seconds()
+	d.last, d.checked = total, now
+}
+
 type aggKey struct {
 	IP       [16]byte
 	Port     uint16
@@ -61,9 +118,10 @@ type aggVal struct {
 	EgressBytes  uint64
 	TotalBytes   uint64
 	IsLocal      bool
+	Estimated    bool
 }
 
-func initialModel(events chan StructEvent) *model {
+func initialModel(source EventSource) *model {
 	vp := viewport.Model{}
 	headerVp := viewport.Model{}
 	vp.YPosition = 5
@@ -73,15 +131,22 @@ func initialModel(events chan StructEvent) *model {
 	ti.Width = 50
 	return &model{
 		currentView: "raw",
-		events:      events,
+		source:      source,
 		rawEvents:   make([]StructEvent, 0, maxRows),
 		aggResults:  make(map[aggKey]aggVal),
+		procResults: make(map[procKey]aggVal),
+		conns:       newConnTracker(),
+		sockFlows:   make(map[uint64]*sockFlow),
 		autoScroll:  true,
 		showLocal:   true,
 		viewport:    vp,
 		headerView:  headerVp,
 		filter: filter{
 			input: ti,
+			where: filterUserspace,
+		},
+		palette: palette{
+			input: newPaletteInput(),
 		},
 	}
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// reloadRequestMsg is sent on SIGHUP.
+type reloadRequestMsg struct{}
+
+type reloadDoneMsg struct {
+	summary string
+	err     error
+}
+
+const paletteHelp = "reload | attach cgroup|tc|xdp [targets] | ring <MiB>"
+
+const paletteDenyHelp = " | block <ip|cidr> [port] [expiry] | limit <ip|cidr> [port] <rate> [expiry] | unblock|unlimit <id>"
+
+func newPaletteInput() textinput.Model {
+	ti := textinput.New()
+	ti.Prompt = ": "
+	ti.Placeholder = paletteHelp
+	ti.CharLimit = 200
+	ti.Width = 60
+	return ti
+}
+
+func (m *model) openPalette() tea.Cmd {
+	if _, ok := m.source.(Reconfigurable); !ok {
+		m.setMessage("this source cannot be reconfigured", true)
+		return nil
+	}
+	m.openPaletteWith("")
+	return textinput.Blink
+}
+
+// openPaletteWith opens the command line with line ready to be run.
+func (m *model) openPaletteWith(line string) {
+	m.palette.active = true
+	m.palette.input.Placeholder = m.paletteHelp()
+	m.palette.input.SetValue(line)
+	m.palette.input.CursorEnd()
+	m.palette.input.Focus()
+}
+
+func (m *model) paletteHelp() string {
+	if m.enforcer() != nil {
+		return paletteHelp + paletteDenyHelp
+	}
+	return paletteHelp
+}
+
+func (m *model) handlePaletteKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.palette.active = false
+		m.palette.input.Blur()
+		m.setMessage("", false)
+		return m, nil
+	case "enter":
+		m.palette.active = false
+		m.palette.input.Blur()
+		return m, m.runPaletteCommand(m.palette.input.Value())
+	}
+
+	var cmd tea.Cmd
+	m.palette.input, cmd = m.palette.input.Update(msg)
+	return m, cmd
+}
+
+func (m *model) runPaletteCommand(line string) tea.Cmd {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	var change func(opts *loaderOptions) error
+	switch fields[0] {
+	case "reload":
+		return m.reloadCmd(nil)
+
+	case "block", "limit":
+		m.runBlock(fields)
+		return nil
+
+	case "unblock", "unlimit":
+		m.runUnblock(fields)
+		return nil
+
+	case "attach":
+		if len(fields) < 2 || len(fields) > 3 {
+			m.setMessage("usage: attach cgroup|tc|xdp [targets]", true)
+			return nil
+		}
+		change = func(opts *loaderOptions) error {
+			opts.Attach = fields[1]
+			var targets []string
+			if len(fields) == 3 {
+				targets = splitList(fields[2])
+			}
+			switch opts.Attach {
+			case attachCgroup:
+				var cgroups cgroupTargets
+				for _, t := range targets {
+					if err := cgroups.Set(t); err != nil {
+						return err
+					}
+				}
+				opts.Targets = cgroups
+			case attachTC, attachXDP:
+				if targets != nil {
+					opts.Interfaces = targets
+				}
+			}
+			return nil
+		}
+
+	case "ring":
+		if len(fields) != 2 {
+			m.setMessage("usage: ring <MiB>", true)
+			return nil
+		}
+		mb, err := strconv.Atoi(fields[1])
+		if err != nil || mb <= 0 {
+			m.setMessage(fmt.Sprintf("ring: invalid size %q", fields[1]), true)
+			return nil
+		}
+		change = func(opts *loaderOptions) error {
+			opts.RingSize = mb * MB
+			return nil
+		}
+
+	default:
+		m.setMessage(fmt.Sprintf("unknown command %q (%s)", fields[0], m.paletteHelp()), true)
+		return nil
+	}
+	return m.reloadCmd(change)
+}
+
+// reloadCmd reconfigures the source in the background. A nil change re-reads
+// the -config file, or just re-attaches when there is none, e.g. to pick up
+// cgroups created since the last attach.
+func (m *model) reloadCmd(change func(opts *loaderOptions) error) tea.Cmd {
+	rc, ok := m.source.(Reconfigurable)
+	if !ok {
+		return nil
+	}
+	m.setMessage("reloading...", false)
+	return func() tea.Msg {
+		opts := rc.Options()
+		var err error
+		switch {
+		case change != nil:
+			err = change(&opts)
+		case opts.ConfigPath != "":
+			opts, err = readLiveConfig(opts)
+		}
+		if err == nil {
+			err = rc.Reload(opts)
+		}
+		if err != nil {
+			return reloadDoneMsg{err: err}
+		}
+		return reloadDoneMsg{summary: describeAttach(opts)}
+	}
+}
+
+func (m *model) handleReloadDone(msg reloadDoneMsg) {
+	if msg.err != nil {
+		m.setMessage(fmt.Sprintf("reload failed, still on the previous setup: %v", msg.err), true)
+		return
+	}
+	m.setMessage("reloaded: "+msg.summary, false)
+}
+
+func describeAttach(opts loaderOptions) string {
+	desc := opts.Attach
+	switch opts.Attach {
+	case attachTC, attachXDP:
+		desc += " " + strings.Join(opts.Interfaces, ",")
+	default:
+		if len(opts.Targets) > 0 {
+			desc += " " + (*cgroupTargets)(&opts.Targets).String()
+		}
+	}
+	if opts.Mode == captureModeRaw && opts.RingSize > 0 {
+		desc += fmt.Sprintf(", ring %d MiB", int(ringBufferSize(opts.RingSize))/MB)
+	}
+	return desc
+}
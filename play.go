@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const playIndexStep = time.Second
+
+type playIndexEntry struct {
+	ts     uint64
+	offset int64
+}
+
+type playSource struct {
+	path  string
+	rr    *recordingReader
+	index []playIndexEntry
+	sink  *eventSink
+	errs  chan error
+	done  chan struct{}
+	wake  chan struct{}
+
+	mu       sync.Mutex
+	speed    float64
+	paused   bool
+	finished bool
+	seekTo   uint64
+	seeks    uint64
+	position uint64
+	recorded SourceStats
+
+	received atomic.Uint64
+}
+
+func newPlaySource(path string, speed float64) *playSource {
+	done := make(chan struct{})
+	return &playSource{
+		path:  path,
+		speed: speed,
+		sink:  newEventSink(defaultQueueBudget, done, dropNever, 0),
+		errs:  make(chan error, 8),
+		done:  done,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (s *playSource) Start() error {
+	rr, err := openRecording(s.path)
+	if err != nil {
+		return err
+	}
+	s.rr = rr
+	for index, name := range rr.Header.Interfaces {
+		registerInterfaceName(index, name)
+	}
+	for id, label := range rr.Header.Attachments {
+		registerAttachLabel(id, label)
+	}
+
+	if err := s.buildIndex(); err != nil {
+		rr.Close()
+		return err
+	}
+
+	go s.readLoop()
+	return nil
+}
+
+// buildIndex scans the recording once and remembers the offset of the first
+// event in every playIndexStep window so seeks don't have to rescan.
+func (s *playSource) buildIndex() error {
+	start := s.rr.Offset()
+	for {
+		offset := s.rr.Offset()
+		ev, err := s.rr.ReadEvent()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+		if n := len(s.index); n == 0 || ev.Timestamp >= s.index[n-1].ts+uint64(playIndexStep) {
+			s.index = append(s.index, playIndexEntry{ts: ev.Timestamp, offset: offset})
+		}
+	}
+	if len(s.index) > 0 {
+		s.position = s.index[0].ts
+	}
+	return s.rr.SeekOffset(start)
+}
+
+func (s *playSource) Stop() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	return nil
+}
+
+func (s *playSource) Events() <-chan EventBatch { return s.sink.events }
+
+func (s *playSource) Errors() <-chan error { return s.errs }
+
+// Stats reports playback's own channel drops alongside the losses the
+// recording session had logged up to the current position.
+func (s *playSource) Stats() SourceStats {
+	s.mu.Lock()
+	recorded := s.recorded
+	s.mu.Unlock()
+	return SourceStats{
+		Received:      s.received.Load(),
+		Dropped:       s.sink.dropped.Load(),
+		KernelDropped: recorded.KernelDropped,
+		ParseErrors:   recorded.ParseErrors,
+	}
+}
+
+func (s *playSource) TogglePause() {
+	s.mu.Lock()
+	s.paused = !s.paused
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *playSource) Seek(d time.Duration) {
+	if len(s.index) == 0 {
+		return
+	}
+	s.mu.Lock()
+	target := int64(s.position) + int64(d)
+	first, last := int64(s.index[0].ts), int64(s.index[len(s.index)-1].ts)
+	s.seekTo = uint64(min(max(target, first), last))
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *playSource) SetSpeed(speed float64) {
+	s.mu.Lock()
+	s.speed = speed
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *playSource) PlaybackState() PlaybackState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return PlaybackState{
+		Host:     s.rr.Header.Hostname,
+		Mode:     s.rr.Header.Mode,
+		Position: time.Unix(0, int64(s.position)),
+		Speed:    s.speed,
+		Paused:   s.paused,
+		Finished: s.finished,
+		Seeks:    s.seeks,
+	}
+}
+
+func (s *playSource) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *playSource) readLoop() {
+	defer close(s.sink.events)
+	defer s.rr.Close()
+
+	s.mu.Lock()
+	p := newPacer(s.speed)
+	s.mu.Unlock()
+	p.idle = s.sink.idle
+
+	var pending *StructEvent
+	var skipUntil uint64
+	for {
+		s.mu.Lock()
+		seekTo, paused, speed := s.seekTo, s.paused, s.speed
+		s.seekTo = 0
+		s.mu.Unlock()
+
+		if seekTo != 0 {
+			if err := s.seek(seekTo); err != nil {
+				log.Printf("Error seeking recording: %v", err)
+				return
+			}
+			pending, skipUntil = nil, seekTo
+			p.rebase()
+		}
+		if speed != p.speed {
+			p.setSpeed(speed)
+		}
+		if paused {
+			s.sink.flush()
+			if !s.sleep() {
+				return
+			}
+			p.rebase()
+			continue
+		}
+
+		if pending == nil {
+			ev, err := s.rr.ReadEvent()
+			s.mu.Lock()
+			s.recorded = s.rr.Stats
+			s.mu.Unlock()
+			if err != nil {
+				if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+					log.Printf("Error reading recording: %v", err)
+				}
+				s.setFinished(true)
+				s.sink.flush()
+				if !s.sleep() {
+					return
+				}
+				continue
+			}
+			if ev.Timestamp < skipUntil {
+				continue
+			}
+			pending = &ev
+		}
+
+		if !p.wait(time.Unix(0, int64(pending.Timestamp)), s.done, s.wake) {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			continue
+		}
+
+		s.received.Add(1)
+		s.sink.add(*pending)
+		s.mu.Lock()
+		s.position = pending.Timestamp
+		s.mu.Unlock()
+		pending = nil
+	}
+}
+
+func (s *playSource) seek(target uint64) error {
+	entry := s.index[0]
+	for _, e := range s.index {
+		if e.ts > target {
+			break
+		}
+		entry = e
+	}
+	if err := s.rr.SeekOffset(entry.offset); err != nil {
+		return err
+	}
+
+	s.sink.reset()
+	s.mu.Lock()
+	s.position = target
+	s.seeks = s.sink.seek
+	s.mu.Unlock()
+	s.setFinished(false)
+	return nil
+}
+
+func (s *playSource) setFinished(finished bool) {
+	s.mu.Lock()
+	s.finished = finished
+	s.mu.Unlock()
+}
+
+func (s *playSource) sleep() bool {
+	select {
+	case <-s.wake:
+		return true
+	case <-s.done:
+		return false
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const procScanInterval = 2 * time.Second
+
+var (
+	procResolverOn atomic.Bool
+	procCommCache  sync.Map
+	userNameCache  sync.Map
+	cgroupPaths    sync.Map
+	cgroupScanMux  sync.Mutex
+	cgroupScanned  time.Time
+
+	portOwnersMux sync.RWMutex
+	portOwners    = make(map[portOwnerKey]uint32)
+)
+
+type portOwnerKey struct {
+	Protocol uint8
+	Port     uint16
+}
+
+type procKey struct {
+	Pid      uint32
+	Comm     string
+	Uid      uint32
+	CgroupID uint64
+}
+
+// initProcResolver enables /proc lookups for events captured on this host.
+// Replays and recordings from other machines leave it off so local process
+// tables are never mixed into foreign data.
+func initProcResolver() {
+	if procResolverOn.Swap(true) {
+		return
+	}
+	go func() {
+		for {
+			scanPortOwners()
+			time.Sleep(procScanInterval)
+		}
+	}()
+}
+
+// processOf returns the owning process of an event, preferring what the
+// kernel recorded and falling back to the local socket tables.
+func processOf(ev StructEvent) procKey {
+	p := procKey{
+		Pid:      ev.key.Pid,
+		Comm:     commString(ev.key.Comm),
+		Uid:      ev.key.Uid,
+		CgroupID: ev.key.CgroupID,
+	}
+	if ev.key.Cookie == 0 || !procResolverOn.Load() {
+		return p
+	}
+
+	if p.Pid == 0 {
+		port := ev.key.Sport
+		if ev.key.Direction == 'i' {
+			port = ev.key.Dport
+		}
+		portOwnersMux.RLock()
+		p.Pid = portOwners[portOwnerKey{Protocol: ev.key.Protocol, Port: port}]
+		portOwnersMux.RUnlock()
+	}
+	if p.Comm == "" && p.Pid != 0 {
+		p.Comm = commForPid(p.Pid)
+	}
+	return p
+}
+
+func (p procKey) String() string {
+	if p.Pid == 0 && p.Comm == "" {
+		return "-"
+	}
+	if p.Comm == "" {
+		return strconv.Itoa(int(p.Pid))
+	}
+	return p.Comm
+}
+
+func commString(comm [16]byte) string {
+	if i := bytes.IndexByte(comm[:], 0); i >= 0 {
+		return string(comm[:i])
+	}
+	return string(comm[:])
+}
+
+func commForPid(pid uint32) string {
+	if comm, ok := procCommCache.Load(pid); ok {
+		return comm.(string)
+	}
+	raw, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	comm := strings.TrimSpace(string(raw))
+	procCommCache.Store(pid, comm)
+	return comm
+}
+
+func userName(uid uint32) string {
+	if name, ok := userNameCache.Load(uid); ok {
+		return name.(string)
+	}
+	name := strconv.Itoa(int(uid))
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	userNameCache.Store(uid, name)
+	return name
+}
+
+// cgroupPathByID maps a cgroup id (the inode of its cgroup2 directory) to a
+// path relative to the cgroup2 mount, rescanning the hierarchy at most every
+// procScanInterval on a miss.
+func cgroupPathByID(id uint64) string {
+	if id == 0 {
+		return "-"
+	}
+	if path, ok := cgroupPaths.Load(id); ok {
+		return path.(string)
+	}
+
+	cgroupScanMux.Lock()
+	defer cgroupScanMux.Unlock()
+	if time.Since(cgroupScanned) > procScanInterval && procResolverOn.Load() {
+		cgroupScanned = time.Now()
+		scanCgroupPaths()
+	}
+	if path, ok := cgroupPaths.Load(id); ok {
+		return path.(string)
+	}
+	return strconv.FormatUint(id, 10)
+}
+
+func scanCgroupPaths() {
+	mount, err := findCgroup2Mount()
+	if err != nil {
+		return
+	}
+	filepath.WalkDir(mount, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			rel, _ := filepath.Rel(mount, path)
+			cgroupPaths.Store(st.Ino, "/"+strings.TrimPrefix(rel, "."))
+		}
+		return nil
+	})
+}
+
+// scanPortOwners rebuilds the (protocol, local port) -> pid table from
+// /proc/net and the socket links in /proc/<pid>/fd.
+func scanPortOwners() {
+	inodes := make(map[uint64]portOwnerKey)
+	for _, table := range []struct {
+		path  string
+		proto uint8
+	}{
+		{"/proc/net/tcp", 6},
+		{"/proc/net/tcp6", 6},
+		{"/proc/net/udp", 17},
+		{"/proc/net/udp6", 17},
+	} {
+		readSocketTable(table.path, table.proto, inodes)
+	}
+
+	owners := make(map[portOwnerKey]uint32)
+	procs, _ := os.ReadDir("/proc")
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(target[len("socket:["):], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if key, ok := inodes[inode]; ok {
+				owners[key] = uint32(pid)
+			}
+		}
+	}
+
+	portOwnersMux.Lock()
+	portOwners = owners
+	portOwnersMux.Unlock()
+}
+
+func readSocketTable(path string, proto uint8, inodes map[uint64]portOwnerKey) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		_, portHex, found := strings.Cut(fields[1], ":")
+		if !found {
+			continue
+		}
+		port, err := strconv.ParseUint(portHex, 16, 16)
+		if err != nil {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || inode == 0 {
+			continue
+		}
+		inodes[inode] = portOwnerKey{Protocol: proto, Port: uint16(port)}
+	}
+}
@@ -31,10 +31,12 @@ func (m *model) updateRawView() {
 	builder.Reset()
 
 	var visibleLines []string
+	var prevTs uint64
 	events := m.filterRawEvents(m.rawEvents)
 	for _, ev := range events {
-		if line := m.renderEventLine(ev); line != "" {
+		if line := m.renderEventLine(ev, prevTs); line != "" {
 			visibleLines = append(visibleLines, line)
+			prevTs = ev.Timestamp
 		}
 	}
 
@@ -53,31 +55,63 @@ func (m *model) updateRawView() {
 
 }
 
-func (m *model) renderEventLine(ev StructEvent) string {
+func (m *model) renderEventLine(ev StructEvent, prevTs uint64) string {
 	srcIP, dstIP := getIPsFromEvent(ev)
-	ipType := "UNKNOWN"
+	remote := srcIP
 	if ev.key.Direction == 'o' {
-		ipType = classifyIPCached(dstIP)
-	} else {
-		ipType = classifyIPCached(srcIP)
+		remote = dstIP
 	}
+	ipType := classifyIPCached(remote)
 	if !m.showLocal && (ipType == IP_TYPE_V4_LOCAL || ipType == IP_TYPE_V6_LOCAL) {
 		return ""
 	}
 
+	proc := processOf(ev)
+	pid, user := "-", "-"
+	if proc.Pid != 0 {
+		pid = fmt.Sprint(proc.Pid)
+	}
+	if ev.key.Cookie != 0 {
+		user = userName(proc.Uid)
+	}
+
+	src, dst := eventEndpoints(ev.key, srcIP, dstIP)
 	protoStyle := protoStyleCache[ev.key.Protocol]
 	dirStyle := dirStyleCache[ev.key.Direction]
 
 	return fmt.Sprintf(format_row,
-		fixedWidth(time.Unix(int64(ev.Timestamp), 0).Format("15:04:05"), timeWidth), coloredSeparator,
-		protoStyle.Render(fixedWidth(protoToString(ev.key.Protocol), protoWidth)), coloredSeparator,
+		fixedWidth(m.formatTime(ev.Timestamp, prevTs), timeWidth), coloredSeparator,
+		protoStyle.Render(fixedWidth(eventProtoString(ev.key), protoWidth)), coloredSeparator,
 		dirStyle.Render(fixedWidth(directionToString(ev.key.Direction), dirWidth)), coloredSeparator,
 		MagentaStyle.Render(fixedWidth(getInterfaceName(ev.key.Ifindex), ifWidth)), coloredSeparator,
-		fixedWidth(fmt.Sprintf("%s:%d", srcIP, ev.key.Sport), srcWidth), coloredSeparator,
-		fixedWidth(fmt.Sprintf("%s:%d", dstIP, ev.key.Dport), dstWidth), coloredSeparator,
+		fixedWidth(getAttachLabel(ev.key.AttachID), cgroupWidth), coloredSeparator,
+		fixedWidth(src, srcWidth), coloredSeparator,
+		fixedWidth(dst, dstWidth), coloredSeparator,
+		fixedWidth(hostName(remote), dnsNameWidth), coloredSeparator,
+		fixedWidth(sniString(flowHostOf(ev)), sniWidth), coloredSeparator,
 		fixedWidth(fmt.Sprintf("%d", ev.val.Bytes), bytesWidth), coloredSeparator,
 		TypeStyle.Render(fixedWidth(ipType, typeWidth)), coloredSeparator,
-		fixedWidth(getPacketTypeName(ev.key.Pkttype), pktTypeWidth),
+		fixedWidth(getPacketTypeName(ev.key.Pkttype), pktTypeWidth), coloredSeparator,
+		MagentaStyle.Render(fixedWidth(proc.String(), commWidth)), coloredSeparator,
+		fixedWidth(pid, pidWidth), coloredSeparator,
+		fixedWidth(user, userWidth),
 	)
 
 }
+
+// formatTime renders an event time in the current time mode; delta mode shows
+// the gap to the previous visible row.
+func (m *model) formatTime(ts, prevTs uint64) string {
+	switch m.timeMode {
+	case timeMillis:
+		return time.Unix(0, int64(ts)).Format("15:04:05.000")
+	case timeMicros:
+		return time.Unix(0, int64(ts)).Format("15:04:05.000000")
+	case timeDelta:
+		if prevTs == 0 || ts < prevTs {
+			return "+0"
+		}
+		return "+" + time.Duration(ts-prevTs).String()
+	}
+	return time.Unix(0, int64(ts)).Format("15:04:05")
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Recording layout: magic, version, a length-prefixed header block, then a
+// stream of records. Each record is a kind byte, a uvarint payload length and
+// the payload, so readers can skip kinds they don't know. The header stores
+// the traffic_event_t layout the events were written in, so a recording stays
+// readable when fields are added or moved. Version 1 did not, and is refused.
+const (
+	recordingMagic   = "IONETREC"
+	recordingVersion = 2
+
+	recordKindEvent = 1
+	recordKindFlow  = 2
+	recordKindStats = 3
+
+	// Sizes read back are checked against these before allocating, so a
+	// corrupt recording fails instead of exhausting memory.
+	maxHeaderSize = 1 << 20
+	maxRecordSize = 64 << 10
+)
+
+type recordingHeader struct {
+	Hostname    string
+	Kernel      string
+	Started     time.Time
+	Interfaces  map[uint32]string
+	Attachments map[uint32]string
+	// Layout is the traffic_event_t layout of the event records.
+	Layout eventLayout
+	// Mode is the capture mode; in flows mode events are per-poll deltas
+	// stamped with the poll time.
+	Mode string
+}
+
+func localRecordingHeader() recordingHeader {
+	hdr := recordingHeader{
+		Started:    time.Now(),
+		Interfaces: make(map[uint32]string),
+	}
+	hdr.Hostname, _ = os.Hostname()
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		hdr.Kernel = strings.TrimSpace(string(release))
+	}
+	if ifaces, err := net.Interfaces(); err == nil {
+		for _, iface := range ifaces {
+			hdr.Interfaces[uint32(iface.Index)] = iface.Name
+		}
+	}
+	return hdr
+}
+
+type recordingWriter struct {
+	f   *os.File
+	w   *bufio.Writer
+	buf bytes.Buffer
+	enc []byte
+}
+
+func createRecording(path string, hdr recordingHeader) (*recordingWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rw := &recordingWriter{f: f, w: bufio.NewWriterSize(f, 1<<20)}
+
+	putString(&rw.buf, hdr.Hostname)
+	putString(&rw.buf, hdr.Kernel)
+	binary.Write(&rw.buf, binary.LittleEndian, hdr.Started.UnixNano())
+	putLabelTable(&rw.buf, hdr.Interfaces)
+	putLabelTable(&rw.buf, hdr.Attachments)
+	putLayout(&rw.buf, trafficEventLayout)
+	putString(&rw.buf, hdr.Mode)
+	if err := rw.writeHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return rw, nil
+}
+
+// writeHeader writes the magic, version and the header block in rw.buf, and
+// flushes them so an unwritable file fails before capture starts.
+func (rw *recordingWriter) writeHeader() error {
+	if rw.buf.Len() > maxHeaderSize {
+		return fmt.Errorf("recording header of %d bytes is larger than %d", rw.buf.Len(), maxHeaderSize)
+	}
+	if _, err := rw.w.WriteString(recordingMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(rw.w, binary.LittleEndian, uint16(recordingVersion)); err != nil {
+		return err
+	}
+	if err := putUvarint(rw.w, uint64(rw.buf.Len())); err != nil {
+		return err
+	}
+	if _, err := rw.w.Write(rw.buf.Bytes()); err != nil {
+		return err
+	}
+	return rw.w.Flush()
+}
+
+// WriteEvent stores single packets as event records and flow-table deltas,
+// which carry a packet count, as flow records.
+func (rw *recordingWriter) WriteEvent(ev StructEvent) error {
+	kind := byte(recordKindEvent)
+	rw.buf.Reset()
+	binary.Write(&rw.buf, binary.LittleEndian, int64(ev.Timestamp))
+	if ev.val.Packets > 1 {
+		kind = recordKindFlow
+		binary.Write(&rw.buf, binary.LittleEndian, ev.val.Packets)
+	}
+	raw := rawEventFrom(ev)
+	rw.enc = appendRawEvent(rw.enc[:0], &raw)
+	rw.buf.Write(rw.enc)
+	return rw.writeRecord(kind, rw.buf.Bytes())
+}
+
+// WriteStats stores the source's loss counters so playback can show what the
+// recording is missing.
+func (rw *recordingWriter) WriteStats(ts time.Time, stats SourceStats) error {
+	rw.buf.Reset()
+	binary.Write(&rw.buf, binary.LittleEndian, ts.UnixNano())
+	binary.Write(&rw.buf, binary.LittleEndian, stats)
+	return rw.writeRecord(recordKindStats, rw.buf.Bytes())
+}
+
+func (rw *recordingWriter) writeRecord(kind byte, payload []byte) error {
+	if err := rw.w.WriteByte(kind); err != nil {
+		return err
+	}
+	if err := putUvarint(rw.w, uint64(len(payload))); err != nil {
+		return err
+	}
+	_, err := rw.w.Write(payload)
+	return err
+}
+
+func (rw *recordingWriter) Close() error {
+	if err := rw.w.Flush(); err != nil {
+		rw.f.Close()
+		return err
+	}
+	return rw.f.Close()
+}
+
+type recordingReader struct {
+	f       *os.File
+	r       *bufio.Reader
+	offset  int64
+	payload []byte
+	// fields moves the fields of recorded events into the current layout;
+	// nil when the layouts are the same.
+	fields  []fieldCopy
+	scratch []byte
+
+	Header recordingHeader
+	// Stats holds the last stats record read.
+	Stats SourceStats
+}
+
+func openRecording(path string) (*recordingReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	rr := &recordingReader{f: f, r: bufio.NewReaderSize(f, 1<<20)}
+	if err := rr.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rr, nil
+}
+
+func (rr *recordingReader) readHeader() error {
+	magic := make([]byte, len(recordingMagic)+2)
+	if _, err := io.ReadFull(rr.r, magic); err != nil {
+		return err
+	}
+	if string(magic[:len(recordingMagic)]) != recordingMagic {
+		return errors.New("not an ionet recording")
+	}
+	switch version := binary.LittleEndian.Uint16(magic[len(recordingMagic):]); {
+	case version < recordingVersion:
+		return fmt.Errorf("recording version %d does not describe its event layout and cannot be decoded reliably; record it again", version)
+	case version > recordingVersion:
+		return fmt.Errorf("unsupported recording version %d", version)
+	}
+	rr.offset = int64(len(magic))
+
+	size, err := rr.readUvarint()
+	if err != nil {
+		return err
+	}
+	if size > maxHeaderSize {
+		return fmt.Errorf("header of %d bytes is larger than %d, the recording is corrupt", size, maxHeaderSize)
+	}
+	block := make([]byte, size)
+	if _, err := io.ReadFull(rr.r, block); err != nil {
+		return err
+	}
+	rr.offset += int64(size)
+
+	br := bytes.NewReader(block)
+	hdr := recordingHeader{}
+	hdr.Hostname = getString(br)
+	hdr.Kernel = getString(br)
+	var started int64
+	binary.Read(br, binary.LittleEndian, &started)
+	hdr.Started = time.Unix(0, started)
+	hdr.Interfaces = getLabelTable(br)
+	hdr.Attachments = getLabelTable(br)
+	layout, err := getLayout(br)
+	if err != nil {
+		return err
+	}
+	hdr.Layout = layout
+	hdr.Mode = getString(br)
+	rr.Header = hdr
+	rr.fields = fieldCopies(layout, trafficEventLayout)
+	return nil
+}
+
+// Next returns the next record. The payload is only valid until the next call.
+func (rr *recordingReader) Next() (byte, []byte, error) {
+	kind, err := rr.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	rr.offset++
+	size, err := rr.readUvarint()
+	if err != nil {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	if size > maxRecordSize {
+		return 0, nil, fmt.Errorf("record of %d bytes at offset %d is larger than %d, the recording is corrupt", size, rr.offset, maxRecordSize)
+	}
+	if cap(rr.payload) < int(size) {
+		rr.payload = make([]byte, size)
+	}
+	rr.payload = rr.payload[:size]
+	if _, err := io.ReadFull(rr.r, rr.payload); err != nil {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	rr.offset += int64(size)
+	return kind, rr.payload, nil
+}
+
+// ReadEvent returns the next event record, skipping records of other kinds.
+func (rr *recordingReader) ReadEvent() (StructEvent, error) {
+	for {
+		kind, payload, err := rr.Next()
+		if err != nil {
+			return StructEvent{}, err
+		}
+		switch kind {
+		case recordKindEvent:
+			return rr.decodeEvent(payload)
+		case recordKindFlow:
+			if len(payload) < 16 {
+				return StructEvent{}, errors.New("short flow record")
+			}
+			packets := binary.LittleEndian.Uint64(payload[8:])
+			ev, err := rr.decodeEvent(append(payload[:8:8], payload[16:]...))
+			ev.val.Packets = packets
+			return ev, err
+		case recordKindStats:
+			rr.Stats = decodeRecordedStats(payload)
+		}
+	}
+}
+
+func (rr *recordingReader) decodeEvent(payload []byte) (StructEvent, error) {
+	if len(payload) < 8+int(rr.Header.Layout.size) {
+		return StructEvent{}, errors.New("short event record")
+	}
+	ts := binary.LittleEndian.Uint64(payload)
+	raw := payload[8:]
+	if rr.fields != nil {
+		rr.scratch = append(rr.scratch[:0], make([]byte, rawEventWireSize)...)
+		for _, f := range rr.fields {
+			copy(rr.scratch[f.to:f.to+f.size], raw[f.from:])
+		}
+		raw = rr.scratch
+	}
+
+	var bpfEvent RawEvent
+	if err := decodeRawEvent(raw, &bpfEvent); err != nil {
+		return StructEvent{}, err
+	}
+	return bpfEvent.structEvent(ts), nil
+}
+
+func decodeRecordedStats(payload []byte) SourceStats {
+	var stats SourceStats
+	if len(payload) < 8 {
+		return stats
+	}
+	raw := make([]byte, binary.Size(stats))
+	copy(raw, payload[8:])
+	binary.Read(bytes.NewReader(raw), binary.LittleEndian, &stats)
+	return stats
+}
+
+func (rr *recordingReader) Offset() int64 { return rr.offset }
+
+func (rr *recordingReader) SeekOffset(offset int64) error {
+	if _, err := rr.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	rr.r.Reset(rr.f)
+	rr.offset = offset
+	rr.Stats = SourceStats{}
+	return nil
+}
+
+func (rr *recordingReader) Close() error { return rr.f.Close() }
+
+func (rr *recordingReader) readUvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(rr.r)
+	if err == nil {
+		rr.offset += int64(uvarintLen(v))
+	}
+	return v, err
+}
+
+func uvarintLen(v uint64) int {
+	var tmp [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(tmp[:], v)
+}
+
+func putUvarint(w io.Writer, v uint64) error {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	_, err := w.Write(tmp[:n])
+	return err
+}
+
+func putString(w io.Writer, s string) {
+	putUvarint(w, uint64(len(s)))
+	io.WriteString(w, s)
+}
+
+func getString(r *bytes.Reader) string {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return ""
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b)
+}
+
+func putLabelTable(w io.Writer, table map[uint32]string) {
+	putUvarint(w, uint64(len(table)))
+	for id, label := range table {
+		putUvarint(w, uint64(id))
+		putString(w, label)
+	}
+}
+
+func getLabelTable(r *bytes.Reader) map[uint32]string {
+	table := make(map[uint32]string)
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return table
+	}
+	for i := uint64(0); i < count; i++ {
+		id, err := binary.ReadUvarint(r)
+		if err != nil {
+			break
+		}
+		table[uint32(id)] = getString(r)
+	}
+	return table
+}
+
+func putLayout(w io.Writer, l eventLayout) {
+	putUvarint(w, uint64(l.size))
+	putUvarint(w, uint64(len(l.members)))
+	for _, m := range l.members {
+		putString(w, m.name)
+		putUvarint(w, uint64(m.offset))
+	}
+}
+
+func getLayout(r *bytes.Reader) (eventLayout, error) {
+	l := eventLayout{name: trafficEventLayout.name}
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > maxRecordSize {
+		return l, errors.New("event layout missing from the header, the recording is corrupt")
+	}
+	l.size = uint32(size)
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > size {
+		return l, errors.New("event layout in the header is corrupt")
+	}
+	for i := uint64(0); i < count; i++ {
+		name := getString(r)
+		off, err := binary.ReadUvarint(r)
+		if err != nil || off >= size || (i > 0 && uint32(off) <= l.members[i-1].offset) {
+			return l, errors.New("event layout in the header is corrupt")
+		}
+		l.members = append(l.members, memberOffset{name, uint32(off)})
+	}
+	return l, nil
+}
+
+// fieldCopy moves one field of a recorded event into the current layout.
+type fieldCopy struct {
+	from, to, size uint32
+}
+
+// fieldCopies maps the fields of events written in layout from to their
+// place in layout to, by name. Fields missing from the recording stay zero.
+// It returns nil when the layouts are the same.
+func fieldCopies(from, to eventLayout) []fieldCopy {
+	if slices.Equal(from.members, to.members) && from.size == to.size {
+		return nil
+	}
+	recorded := make(map[string]int, len(from.members))
+	for i, m := range from.members {
+		recorded[m.name] = i
+	}
+	copies := []fieldCopy{}
+	for i, m := range to.members {
+		j, ok := recorded[m.name]
+		if !ok {
+			continue
+		}
+		size := min(from.memberSize(j), to.memberSize(i))
+		copies = append(copies, fieldCopy{from: from.members[j].offset, to: m.offset, size: size})
+	}
+	return copies
+}
+
+// memberSize is the size of member i, up to the next member or the end of
+// the struct; the layouts are packed.
+func (l eventLayout) memberSize(i int) uint32 {
+	if i+1 < len(l.members) {
+		return l.members[i+1].offset - l.members[i].offset
+	}
+	return l.size - l.members[i].offset
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.rec")
+	hdr := recordingHeader{
+		Hostname:    "host-a",
+		Kernel:      "6.8.0",
+		Started:     time.Unix(1700000000, 5),
+		Interfaces:  map[uint32]string{1: "lo", 2: "eth0"},
+		Attachments: map[uint32]string{1: "/sys/fs/cgroup"},
+		Mode:        captureModeRaw,
+	}
+
+	single := event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 1500)
+	single.Timestamp = 1700000001000000000
+	single.key.Pid, single.key.Uid, single.key.TcpFlags = 42, 1000, tcpSYN|tcpACK
+	copy(single.key.Comm[:], "curl")
+	flow := event4('i', 17, "10.0.0.2", "10.2.2.2", 5353, 53, 9000)
+	flow.Timestamp = 1700000002000000000
+	flow.val.Packets = 12
+	stats := SourceStats{Received: 2, Dropped: 3, KernelDropped: 4, ParseErrors: 5}
+
+	rw, err := createRecording(path, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []StructEvent{single, flow} {
+		if err := rw.WriteEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.WriteStats(time.Unix(1700000003, 0), stats); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rr, err := openRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+
+	got := rr.Header
+	if got.Hostname != hdr.Hostname || got.Kernel != hdr.Kernel || !got.Started.Equal(hdr.Started) ||
+		got.Interfaces[2] != "eth0" || got.Attachments[1] != "/sys/fs/cgroup" || got.Mode != hdr.Mode {
+		t.Errorf("header = %+v, want %+v", got, hdr)
+	}
+	for _, want := range []StructEvent{single, flow} {
+		ev, err := rr.ReadEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev != want {
+			t.Errorf("event = %+v, want %+v", ev, want)
+		}
+	}
+	if _, err := rr.ReadEvent(); !errors.Is(err, io.EOF) {
+		t.Errorf("after last event: %v, want EOF", err)
+	}
+	if rr.Stats != stats {
+		t.Errorf("stats = %+v, want %+v", rr.Stats, stats)
+	}
+}
+
+// recordingFile writes a recording with an otherwise empty header that
+// declares layout, followed by records, and returns its path.
+func recordingFile(t *testing.T, version uint16, layout eventLayout, records []byte) string {
+	t.Helper()
+	var block bytes.Buffer
+	putString(&block, "")
+	putString(&block, "")
+	binary.Write(&block, binary.LittleEndian, int64(0))
+	putLabelTable(&block, nil)
+	putLabelTable(&block, nil)
+	putLayout(&block, layout)
+
+	var b bytes.Buffer
+	b.WriteString(recordingMagic)
+	binary.Write(&b, binary.LittleEndian, version)
+	putUvarint(&b, uint64(block.Len()))
+	b.Write(block.Bytes())
+	b.Write(records)
+	path := filepath.Join(t.TempDir(), "capture.rec")
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRecordingRejectsOversizedBlocks(t *testing.T) {
+	var b bytes.Buffer
+	b.WriteString(recordingMagic)
+	binary.Write(&b, binary.LittleEndian, uint16(recordingVersion))
+	putUvarint(&b, 1<<40)
+	path := filepath.Join(t.TempDir(), "header.rec")
+	os.WriteFile(path, b.Bytes(), 0o644)
+	if _, err := openRecording(path); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("oversized header: %v, want a corrupt recording error", err)
+	}
+
+	record := binary.AppendUvarint([]byte{recordKindEvent}, 1<<40)
+	rr, err := openRecording(recordingFile(t, recordingVersion, trafficEventLayout, record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	if _, err := rr.ReadEvent(); err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("oversized record: %v, want a corrupt recording error", err)
+	}
+}
+
+func TestRecordingRejectsVersion1(t *testing.T) {
+	_, err := openRecording(recordingFile(t, 1, trafficEventLayout, nil))
+	if err == nil || !strings.Contains(err.Error(), "version 1") {
+		t.Errorf("version 1 recording: %v, want an error naming the version", err)
+	}
+}
+
+// Events recorded in another traffic_event_t layout are decoded by field
+// name: here pid is missing and an unknown field trails the struct.
+func TestRecordingDecodesRecordedLayout(t *testing.T) {
+	ev := event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 1500)
+	ev.Timestamp = 1700000001000000000
+	ev.key.Pid, ev.key.Uid = 42, 1000
+	copy(ev.key.Comm[:], "curl")
+	raw := rawEventFrom(ev)
+	cur := appendRawEvent(nil, &raw)
+
+	const pidSize = offComm - offPid
+	var old eventLayout
+	for _, m := range trafficEventLayout.members {
+		switch {
+		case m.name == "pid":
+			continue
+		case m.offset > offPid:
+			m.offset -= pidSize
+		}
+		old.members = append(old.members, m)
+	}
+	old.members = append(old.members, memberOffset{"legacy", rawEventWireSize - pidSize})
+	old.size = rawEventWireSize - pidSize + 4
+	payload := binary.LittleEndian.AppendUint64(nil, ev.Timestamp)
+	payload = append(payload, cur[:offPid]...)
+	payload = append(payload, cur[offComm:]...)
+	payload = append(payload, 0xff, 0xff, 0xff, 0xff)
+	record := binary.AppendUvarint([]byte{recordKindEvent}, uint64(len(payload)))
+
+	rr, err := openRecording(recordingFile(t, recordingVersion, old, append(record, payload...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	got, err := rr.ReadEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ev
+	want.key.Pid = 0
+	if got != want {
+		t.Errorf("event = %+v, want %+v", got, want)
+	}
+}
+
+func TestRecordingReportsWriteErrors(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	if _, err := createRecording("/dev/full", recordingHeader{}); err == nil {
+		t.Error("createRecording on a full device succeeded")
+	}
+}
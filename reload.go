@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+
+	"github.com/cilium/ebpf"
+)
+
+// Reconfigurable is implemented by sources whose capture setup can be
+// replaced while running. Everything already delivered stays with the model.
+type Reconfigurable interface {
+	Options() loaderOptions
+	Reload(opts loaderOptions) error
+}
+
+// liveConfig is the part of the capture setup read from -config. It is
+// applied at startup and again on SIGHUP or :reload; unset fields keep their
+// current values.
+type liveConfig struct {
+	Attach  string   `json:"attach"`
+	Iface   []string `json:"iface"`
+	Cgroups []string `json:"cgroups"`
+	RingMB  int      `json:"ring_mb"`
+	Sample  uint32   `json:"sample"`
+}
+
+// readLiveConfig applies the config file at opts.ConfigPath to opts.
+func readLiveConfig(opts loaderOptions) (loaderOptions, error) {
+	data, err := os.ReadFile(opts.ConfigPath)
+	if err != nil {
+		return opts, err
+	}
+	var cfg liveConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return opts, fmt.Errorf("%s: %w", opts.ConfigPath, err)
+	}
+
+	if cfg.Attach != "" {
+		opts.Attach = cfg.Attach
+	}
+	if cfg.Iface != nil {
+		opts.Interfaces = cfg.Iface
+	}
+	if cfg.Cgroups != nil {
+		var targets cgroupTargets
+		for _, c := range cfg.Cgroups {
+			if err := targets.Set(c); err != nil {
+				return opts, fmt.Errorf("%s: %w", opts.ConfigPath, err)
+			}
+		}
+		opts.Targets = targets
+	}
+	if cfg.RingMB != 0 {
+		opts.RingSize = cfg.RingMB * MB
+	}
+	if cfg.Sample != 0 {
+		opts.SampleRate = min(cfg.Sample, maxSampleRate)
+	}
+	return opts, nil
+}
+
+func (s *bpfSource) Options() loaderOptions {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.opts
+}
+
+// Reload loads and attaches a new generation for opts and hands it to the
+// reader, carrying over the kernel filter and the deny rules. The capture
+// mode, enforcement and the queue settings are fixed for the life of the
+// source. On error the running
+// generation is left untouched.
+func (s *bpfSource) Reload(opts loaderOptions) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	select {
+	case <-s.done:
+		return errors.New("source stopped")
+	default:
+	}
+
+	cur := s.Options()
+	if opts.Mode != cur.Mode {
+		return errors.New("the capture mode cannot be changed while running")
+	}
+	opts.PollInterval, opts.Enforce, opts.Limits = cur.PollInterval, cur.Enforce, cur.Limits
+	opts.DropPolicy, opts.BlockTimeout, opts.QueueBudget = cur.DropPolicy, cur.BlockTimeout, cur.QueueBudget
+	if err := checkLoaderOptions(opts); err != nil {
+		return err
+	}
+	points, err := resolveAttachPoints(opts)
+	if err != nil {
+		return err
+	}
+
+	// The filter and the deny rules go into the new maps before anything is
+	// attached, so no packet sees them unconfigured. A change racing with
+	// the load is caught by the version checks and written again.
+	s.configMu.Lock()
+	prev, kf, cfg, version := s.gen, s.filter, s.config, s.filterVersion
+	deny, denyVersion := maps.Clone(s.deny), s.denyVersion
+	s.configMu.Unlock()
+	if opts.SampleRate != cur.SampleRate {
+		cfg.SampleRate = max(opts.SampleRate, 1)
+	}
+	setup := func(maps map[string]*ebpf.Map) error {
+		if err := writeFilter(maps, kf, cfg); err != nil {
+			return err
+		}
+		return writeDenyRules(maps, deny)
+	}
+	gen, err := LoadAndAttach(opts, points, prev, setup)
+	if err != nil {
+		return err
+	}
+
+	s.configMu.Lock()
+	if s.filterVersion != version {
+		cfg = s.config
+		if err := writeFilter(gen.maps(), s.filter, cfg); err != nil {
+			log.Printf("reload: restoring the kernel filter: %v", err)
+		}
+	}
+	if s.denyVersion != denyVersion {
+		if err := writeDenyRules(gen.maps(), s.deny); err != nil {
+			log.Printf("reload: restoring the deny rules: %v", err)
+		}
+	}
+	s.retired = s.retired.add(prev.kernelStats())
+	s.retireDeny(prev)
+	s.gen, s.opts, s.config = gen, opts, cfg
+	s.configMu.Unlock()
+	gen.readNames()
+	go s.readSock(gen.sock)
+
+	select {
+	case s.handover <- gen:
+	case <-s.done:
+		prev.Close()
+	}
+	return nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const pcapngMagic = 0x0A0D0D0A
+
+type packetReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+}
+
+type replaySource struct {
+	path  string
+	speed float64
+	local []*net.IPNet
+	file  *os.File
+	sink  *eventSink
+	errs  chan error
+	done  chan struct{}
+
+	received atomic.Uint64
+}
+
+func newReplaySource(path string, speed float64, local []*net.IPNet) *replaySource {
+	done := make(chan struct{})
+	return &replaySource{
+		path:  path,
+		speed: speed,
+		local: local,
+		sink:  newEventSink(defaultQueueBudget, done, dropNever, 0),
+		errs:  make(chan error, 8),
+		done:  done,
+	}
+}
+
+func (s *replaySource) Start() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	s.file = f
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(4)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("read %s: %w", s.path, err)
+	}
+
+	if binary.LittleEndian.Uint32(magic) == pcapngMagic {
+		r, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("open pcapng: %w", err)
+		}
+		go s.readLoop(r, func(ci gopacket.CaptureInfo) layers.LinkType {
+			iface, err := r.Interface(ci.InterfaceIndex)
+			if err != nil {
+				return r.LinkType()
+			}
+			if iface.Name != "" {
+				registerInterfaceName(uint32(ci.InterfaceIndex), iface.Name)
+			}
+			return iface.LinkType
+		})
+		return nil
+	}
+
+	r, err := pcapgo.NewReader(br)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("open pcap: %w", err)
+	}
+	go s.readLoop(r, func(gopacket.CaptureInfo) layers.LinkType {
+		return r.LinkType()
+	})
+	return nil
+}
+
+func (s *replaySource) Stop() error {
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	if s.file != nil {
+		return s.file.Close()
+	}
+	return nil
+}
+
+func (s *replaySource) Events() <-chan EventBatch { return s.sink.events }
+
+func (s *replaySource) Errors() <-chan error { return s.errs }
+
+func (s *replaySource) Stats() SourceStats {
+	return SourceStats{
+		Received: s.received.Load(),
+		Dropped:  s.sink.dropped.Load(),
+	}
+}
+
+func (s *replaySource) readLoop(r packetReader, linkType func(gopacket.CaptureInfo) layers.LinkType) {
+	defer close(s.sink.events)
+
+	p := newPacer(s.speed)
+	p.idle = s.sink.idle
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrClosed) {
+				log.Printf("Error reading capture: %v", err)
+			}
+			s.sink.flush()
+			return
+		}
+
+		packet := gopacket.NewPacket(data, linkType(ci), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+		ev, ok := s.decodePacket(packet, ci)
+		if !ok {
+			continue
+		}
+
+		if !p.wait(ci.Timestamp, s.done, nil) {
+			return
+		}
+		dnsClock.Store(ci.Timestamp.UnixNano())
+		observePayloads(packet, ev, ci.Timestamp)
+		s.received.Add(1)
+		s.sink.add(ev)
+	}
+}
+
+func (s *replaySource) decodePacket(packet gopacket.Packet, ci gopacket.CaptureInfo) (StructEvent, bool) {
+	ev := StructEvent{
+		key: KeyEvent{
+			Ifindex: uint32(ci.InterfaceIndex),
+		},
+		val: Stats{
+			Packets: 1,
+		},
+		Timestamp: uint64(ci.Timestamp.UnixNano()),
+	}
+
+	var srcIP, dstIP net.IP
+	var fragment []byte
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		ev.key.Family = 2
+		ev.key.Protocol = uint8(ip.Protocol)
+		ev.key.Saddr = binary.LittleEndian.Uint32(ip.SrcIP.To4())
+		ev.key.Daddr = binary.LittleEndian.Uint32(ip.DstIP.To4())
+		ev.val.Bytes = uint64(ip.Length)
+		srcIP, dstIP = ip.SrcIP, ip.DstIP
+		if ip.Flags&layers.IPv4MoreFragments != 0 || ip.FragOffset != 0 {
+			ev.key.Flags |= eventFragment
+			fragment = ip.Payload
+		}
+		if ip.FragOffset != 0 {
+			ev.key.Flags |= eventNoL4
+		}
+	case *layers.IPv6:
+		ev.key.Family = 10
+		ev.key.Protocol, fragment = ipv6Protocol(packet, ip, &ev.key.Flags)
+		copy(ev.key.SaddrV6[:], ip.SrcIP.To16())
+		copy(ev.key.DaddrV6[:], ip.DstIP.To16())
+		ev.val.Bytes = uint64(ip.Length) + 40
+		srcIP, dstIP = ip.SrcIP, ip.DstIP
+	default:
+		return ev, false
+	}
+
+	switch l4 := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		ev.key.Protocol = uint8(layers.IPProtocolTCP)
+		ev.key.Sport = uint16(l4.SrcPort)
+		ev.key.Dport = uint16(l4.DstPort)
+		ev.key.TcpFlags = tcpFlagsOf(l4)
+		ev.key.Seq, ev.key.Ack, ev.key.Window = l4.Seq, l4.Ack, l4.Window
+	case *layers.UDP:
+		ev.key.Protocol = uint8(layers.IPProtocolUDP)
+		ev.key.Sport = uint16(l4.SrcPort)
+		ev.key.Dport = uint16(l4.DstPort)
+	default:
+		if l := packet.Layer(layers.LayerTypeICMPv4); l != nil && ev.key.Flags&eventNoL4 == 0 {
+			ev.key.Sport, ev.key.Dport = icmpPorts(protoICMP, slices.Concat(l.LayerContents(), l.LayerPayload()))
+		}
+		if l := packet.Layer(layers.LayerTypeICMPv6); l != nil {
+			ev.key.Protocol = uint8(layers.IPProtocolICMPv6)
+			ev.key.Sport, ev.key.Dport = icmpPorts(protoICMPv6, slices.Concat(l.LayerContents(), l.LayerPayload()))
+		}
+		// gopacket does not decode past a fragment header, but a first
+		// fragment still starts with the transport header.
+		if ev.key.Flags&eventNoL4 == 0 && len(fragment) >= 4 &&
+			(ev.key.Protocol == uint8(layers.IPProtocolTCP) || ev.key.Protocol == uint8(layers.IPProtocolUDP)) {
+			ev.key.Sport = binary.BigEndian.Uint16(fragment)
+			ev.key.Dport = binary.BigEndian.Uint16(fragment[2:])
+		}
+	}
+
+	ev.key.Direction = s.direction(srcIP, dstIP, ev.key.Sport, ev.key.Dport)
+	if ev.key.Direction == 'o' {
+		ev.key.Pkttype = 4
+	}
+	return ev, true
+}
+
+func tcpFlagsOf(tcp *layers.TCP) uint8 {
+	var flags uint8
+	for _, f := range []struct {
+		set  bool
+		flag uint8
+	}{
+		{tcp.FIN, tcpFIN}, {tcp.SYN, tcpSYN}, {tcp.RST, tcpRST}, {tcp.PSH, tcpPSH},
+		{tcp.ACK, tcpACK}, {tcp.URG, tcpURG}, {tcp.ECE, tcpECE}, {tcp.CWR, tcpCWR},
+	} {
+		if f.set {
+			flags |= f.flag
+		}
+	}
+	return flags
+}
+
+// ipv6Protocol returns the protocol after the extension headers gopacket
+// decoded, flagging fragments the way the eBPF programs do. For fragments it
+// also returns the payload following the fragment header.
+func ipv6Protocol(packet gopacket.Packet, ip *layers.IPv6, flags *uint8) (uint8, []byte) {
+	proto := ip.NextHeader
+	var fragment []byte
+	for _, layer := range packet.Layers() {
+		switch ext := layer.(type) {
+		case *layers.IPv6HopByHop:
+			proto = ext.NextHeader
+		case *layers.IPv6Routing:
+			proto = ext.NextHeader
+		case *layers.IPv6Destination:
+			proto = ext.NextHeader
+		case *layers.IPv6Fragment:
+			proto = ext.NextHeader
+			fragment = ext.Payload
+			*flags |= eventFragment
+			if ext.FragmentOffset != 0 {
+				*flags |= eventNoL4
+			}
+		}
+	}
+	return uint8(proto), fragment
+}
+
+// observePayloads learns names from the DNS responses and ClientHellos of
+// a packet. The replay calls it once the packet is due, with its capture
+// time, so names appear and expire in recording time.
+func observePayloads(packet gopacket.Packet, ev StructEvent, ts time.Time) {
+	switch l4 := packet.TransportLayer().(type) {
+	case *layers.TCP:
+		if l4.SrcPort == 53 && len(l4.Payload) > 2 {
+			observeDNS(l4.Payload[2:], ts)
+		}
+		if ev.key.Direction == 'o' && (ev.key.Dport == 443 || ev.key.Dport == 80) && len(l4.Payload) > 0 {
+			observeHello(ev, l4.Payload)
+		}
+	case *layers.UDP:
+		if l4.SrcPort == 53 {
+			observeDNS(l4.Payload, ts)
+		}
+	}
+}
+
+func (s *replaySource) direction(src, dst net.IP, sport, dport uint16) byte {
+	if len(s.local) > 0 {
+		for _, n := range s.local {
+			if n.Contains(src) {
+				return 'o'
+			}
+		}
+		return 'i'
+	}
+
+	srcLocal, dstLocal := isLocalIP(src), isLocalIP(dst)
+	switch {
+	case srcLocal && !dstLocal:
+		return 'o'
+	case dstLocal && !srcLocal:
+		return 'i'
+	case sport > dport:
+		return 'o'
+	}
+	return 'i'
+}
+
+type pacer struct {
+	speed     float64
+	firstTs   time.Time
+	wallStart time.Time
+	// idle, if set, runs before wait sleeps, e.g. to flush a partial batch.
+	idle func(delay time.Duration)
+}
+
+func newPacer(speed float64) *pacer {
+	return &pacer{speed: speed}
+}
+
+func (p *pacer) rebase() {
+	p.firstTs = time.Time{}
+}
+
+func (p *pacer) setSpeed(speed float64) {
+	p.speed = speed
+	p.rebase()
+}
+
+// wait blocks until ts is due relative to the first timestamp seen since the
+// last rebase. It returns false if done or wake fired first.
+func (p *pacer) wait(ts time.Time, done, wake <-chan struct{}) bool {
+	if p.speed <= 0 {
+		return true
+	}
+	if p.firstTs.IsZero() {
+		p.firstTs = ts
+		p.wallStart = time.Now()
+		return true
+	}
+
+	offset := time.Duration(float64(ts.Sub(p.firstTs)) / p.speed)
+	delay := time.Until(p.wallStart.Add(offset))
+	if delay <= 0 {
+		return true
+	}
+	if p.idle != nil {
+		p.idle(delay)
+		delay = time.Until(p.wallStart.Add(offset))
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	case <-wake:
+		return false
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+const (
+	maxFlowHosts = 16384
+	// maxEndpointHosts bounds the names remembered per remote address and
+	// port in the agg view.
+	maxEndpointHosts = 8
+)
+
+type flowHost struct {
+	name string
+	seen time.Time
+}
+
+type endpointKey struct {
+	IP   [16]byte
+	Port uint16
+}
+
+// flowHosts holds the server name each outbound connection asked for, from
+// the SNI of its TLS ClientHello or the Host header of its HTTP request.
+// endpointHosts collects them per remote address and port, newest first.
+var (
+	flowHosts     = make(map[connKey]flowHost)
+	endpointHosts = make(map[endpointKey][]string)
+	flowHostsMu   sync.RWMutex
+)
+
+// readHello parses the payloads copied by capture_hello until rd is closed.
+func readHello(rd *ringbuf.Reader) {
+	var record ringbuf.Record
+	var ev RawEvent
+	for {
+		if err := rd.ReadInto(&record); err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+			log.Printf("Error reading from %s: %v", bpfMapHello, err)
+			continue
+		}
+		sample := record.RawSample
+		if err := decodeRawEvent(sample, &ev); err != nil || len(sample) < rawEventWireSize+4 {
+			continue
+		}
+		n := binary.LittleEndian.Uint32(sample[rawEventWireSize:])
+		payload := sample[rawEventWireSize+4:]
+		if int(n) > len(payload) {
+			continue
+		}
+		observeHello(ev.structEvent(0), payload[:n])
+	}
+}
+
+// observeHello records the server name carried by the first payload of an
+// outbound connection.
+func observeHello(ev StructEvent, payload []byte) {
+	name := clientHelloSNI(payload)
+	if name == "" {
+		name = httpHost(payload)
+	}
+	if name == "" {
+		return
+	}
+	name = strings.ToLower(name)
+	key := connKeyOf(ev)
+
+	flowHostsMu.Lock()
+	defer flowHostsMu.Unlock()
+	if _, ok := flowHosts[key]; !ok && len(flowHosts) >= maxFlowHosts {
+		evictOldestHosts()
+	}
+	flowHosts[key] = flowHost{name: name, seen: time.Now()}
+
+	ep := endpointKey{key.Remote, key.RemotePort}
+	names := slices.DeleteFunc(endpointHosts[ep], func(n string) bool { return n == name })
+	names = append([]string{name}, names...)
+	endpointHosts[ep] = names[:min(len(names), maxEndpointHosts)]
+}
+
+// evictOldestHosts drops the oldest tenth of flowHosts, and the endpoints
+// left without connections. It must be called with flowHostsMu held.
+func evictOldestHosts() {
+	seen := make([]time.Time, 0, len(flowHosts))
+	for _, h := range flowHosts {
+		seen = append(seen, h.seen)
+	}
+	slices.SortFunc(seen, time.Time.Compare)
+	cutoff := seen[len(seen)/10]
+	for key, h := range flowHosts {
+		if !h.seen.After(cutoff) {
+			delete(flowHosts, key)
+		}
+	}
+	live := make(map[endpointKey]bool, len(flowHosts))
+	for key := range flowHosts {
+		live[endpointKey{key.Remote, key.RemotePort}] = true
+	}
+	for ep := range endpointHosts {
+		if !live[ep] {
+			delete(endpointHosts, ep)
+		}
+	}
+}
+
+// flowHostOf returns the server name of the connection ev belongs to.
+func flowHostOf(ev StructEvent) string {
+	if ev.key.Protocol != 6 {
+		return ""
+	}
+	return flowHostFor(connKeyOf(ev))
+}
+
+func flowHostFor(key connKey) string {
+	flowHostsMu.RLock()
+	defer flowHostsMu.RUnlock()
+	return flowHosts[key].name
+}
+
+// endpointHostsFor returns the server names seen for a remote TCP address
+// and port, newest first.
+func endpointHostsFor(ip [16]byte, port uint16) []string {
+	flowHostsMu.RLock()
+	defer flowHostsMu.RUnlock()
+	return slices.Clone(endpointHosts[endpointKey{ip, port}])
+}
+
+func sniString(name string) string {
+	if name == "" {
+		return "-"
+	}
+	return name
+}
+
+// hostsString shows the newest name and how many others were seen.
+func hostsString(names []string) string {
+	switch len(names) {
+	case 0:
+		return "-"
+	case 1:
+		return names[0]
+	}
+	return fmt.Sprintf("%s +%d", names[0], len(names)-1)
+}
+
+// sniMatchesFilter matches a name against comma-separated globs such as
+// "*.example.com"; a bare name matches as a substring.
+func sniMatchesFilter(name, filterStr string) bool {
+	if name == "" {
+		return false
+	}
+	for _, pattern := range strings.Split(strings.ToLower(filterStr), ",") {
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+			continue
+		}
+		if strings.Contains(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientHelloSNI returns the server_name of a TLS ClientHello, or "" when
+// payload is not one or is cut off before the extension.
+func clientHelloSNI(b []byte) string {
+	// record header: type, version, length; handshake header: type, length
+	if len(b) < 9 || b[0] != 0x16 || b[5] != 0x01 {
+		return ""
+	}
+	b = b[9:]
+	// client_version, random
+	if len(b) < 34 {
+		return ""
+	}
+	b = b[34:]
+
+	skip := func(lenBytes int) bool {
+		if len(b) < lenBytes {
+			return false
+		}
+		n := 0
+		for _, c := range b[:lenBytes] {
+			n = n<<8 | int(c)
+		}
+		if len(b) < lenBytes+n {
+			return false
+		}
+		b = b[lenBytes+n:]
+		return true
+	}
+	// session_id, cipher_suites, compression_methods
+	if !skip(1) || !skip(2) || !skip(1) || len(b) < 2 {
+		return ""
+	}
+	b = b[2:] // extensions length; a truncated list is walked as far as it goes
+
+	for len(b) >= 4 {
+		typ := binary.BigEndian.Uint16(b)
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+		if len(b) < n {
+			return ""
+		}
+		if typ != 0 {
+			b = b[n:]
+			continue
+		}
+		// server_name_list: length, then entries of type, length, name
+		ext := b[:n]
+		if len(ext) < 5 || ext[2] != 0 {
+			return ""
+		}
+		nameLen := int(binary.BigEndian.Uint16(ext[3:]))
+		if len(ext) < 5+nameLen {
+			return ""
+		}
+		return string(ext[5 : 5+nameLen])
+	}
+	return ""
+}
+
+// httpHost returns the Host header of an HTTP/1.x request without its port.
+func httpHost(b []byte) string {
+	// Without the blank line the request was cut off; its last line may be
+	// incomplete.
+	end := bytes.Index(b, []byte("\r\n\r\n"))
+	if end < 0 {
+		end = bytes.LastIndex(b, []byte("\r\n"))
+	}
+	if end < 0 {
+		return ""
+	}
+	for _, line := range bytes.Split(b[:end], []byte("\r\n"))[1:] {
+		name, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok || !strings.EqualFold(string(name), "host") {
+			continue
+		}
+		host := strings.TrimSpace(string(value))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return host
+	}
+	return ""
+}
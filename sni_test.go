@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// clientHello returns the first record a Go TLS client sends for serverName.
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+	buf := make([]byte, 1<<14)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func TestClientHelloSNI(t *testing.T) {
+	hello := clientHello(t, "api.example.com")
+	noSNI := clientHello(t, "")
+	for _, tt := range []struct {
+		name    string
+		payload []byte
+		want    string
+	}{
+		{"client hello", hello, "api.example.com"},
+		{"no server_name", noSNI, ""},
+		{"cut off in the random", hello[:20], ""},
+		{"cut off in the extensions", hello[:60], ""},
+		{"application data", append([]byte{0x17}, hello[1:]...), ""},
+		{"server hello", append(append([]byte{}, hello[:5]...), append([]byte{0x02}, hello[6:]...)...), ""},
+		{"empty", nil, ""},
+	} {
+		if got := clientHelloSNI(tt.payload); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPHost(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"get", "GET / HTTP/1.1\r\nHost: example.com\r\nAccept: */*\r\n\r\n", "example.com"},
+		{"port", "POST /api HTTP/1.1\r\nhost: example.com:8080\r\n\r\nbody", "example.com"},
+		{"ipv6", "GET / HTTP/1.1\r\nHost: [2001:db8::1]:80\r\n\r\n", "2001:db8::1"},
+		{"cut off after host", "GET / HTTP/1.1\r\nHost: example.com\r\nUser-Ag", "example.com"},
+		{"cut off in host", "GET / HTTP/1.1\r\nHost: examp", ""},
+		{"host in body", "GET / HTTP/1.1\r\nAccept: */*\r\n\r\nHost: example.com\r\n", ""},
+		{"request line only", "GET / HTTP/1.0\r\n\r\n", ""},
+		{"not http", "\x16\x03\x01\x02\x00", ""},
+	} {
+		if got := httpHost([]byte(tt.payload)); got != tt.want {
+			t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// sockFlow is the traffic seen on the socket of a sock event, matched by
+// socket cookie.
+type sockFlow struct {
+	Packets   uint64
+	BytesIn   uint64
+	BytesOut  uint64
+	Estimated bool
+	// Conn is the TCP connection the socket's packets belong to.
+	Conn connKey
+	TCP  bool
+}
+
+// drainSockEvents takes whatever socket calls are queued without waiting.
+func (m *model) drainSockEvents(ch <-chan SockEvent) {
+	batch := m.sockScratch[:0]
+drain:
+	for range sockQueueLen {
+		select {
+		case ev := <-ch:
+			batch = append(batch, ev)
+		default:
+			break drain
+		}
+	}
+	m.sockScratch = batch
+	if len(batch) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sockEvents = append(m.sockEvents, batch...)
+	if over := len(m.sockEvents) - maxRows; over > 0 {
+		n := copy(m.sockEvents, m.sockEvents[over:])
+		m.sockEvents = m.sockEvents[:n]
+	}
+	for _, ev := range batch {
+		if ev.Cookie != 0 && m.sockFlows[ev.Cookie] == nil {
+			m.sockFlows[ev.Cookie] = &sockFlow{}
+		}
+	}
+	if len(m.sockFlows) > 2*maxRows {
+		live := make(map[uint64]*sockFlow, len(m.sockEvents))
+		for _, ev := range m.sockEvents {
+			if f := m.sockFlows[ev.Cookie]; f != nil {
+				live[ev.Cookie] = f
+			}
+		}
+		m.sockFlows = live
+	}
+}
+
+// observeSockFlow adds ev to the flow of its socket, if a sock event named
+// it. It must be called with m.mu held.
+func (m *model) observeSockFlow(ev StructEvent) {
+	if ev.key.Cookie == 0 {
+		return
+	}
+	f := m.sockFlows[ev.key.Cookie]
+	if f == nil {
+		return
+	}
+	scale := uint64(max(ev.key.SampleRate, 1))
+	f.Packets += ev.val.Packets * scale
+	if ev.key.Direction == 'o' {
+		f.BytesOut += ev.val.Bytes * scale
+	} else {
+		f.BytesIn += ev.val.Bytes * scale
+	}
+	f.Estimated = f.Estimated || scale > 1
+	if ev.key.Protocol == 6 && ev.key.Flags&eventNoL4 == 0 {
+		f.Conn, f.TCP = connKeyOf(ev), true
+	}
+}
+
+func (m *model) updateSockView() {
+	m.headerView.SetContent(tableHeaderSock)
+
+	lines := make([]string, 0, len(m.sockEvents))
+	var prevTs uint64
+	for _, ev := range m.sockEvents {
+		if !m.showLocal && isLocalIP(ev.IP()) && ev.Kind != sockBind {
+			continue
+		}
+		if !m.matchesSockFilter(ev) {
+			continue
+		}
+		lines = append(lines, m.renderSockLine(ev, prevTs))
+		prevTs = ev.Timestamp
+	}
+	m.viewport.SetContent(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+func (m *model) renderSockLine(ev SockEvent, prevTs uint64) string {
+	state, style, flow := m.sockFlowState(ev)
+	pkts, in, out := "-", "-", "-"
+	if flow.Packets > 0 {
+		pkts = estimate(fmt.Sprint(flow.Packets), flow.Estimated)
+	}
+	if flow.BytesIn+flow.BytesOut > 0 {
+		in = estimate(parseBytes(flow.BytesIn), flow.Estimated)
+		out = estimate(parseBytes(flow.BytesOut), flow.Estimated)
+	}
+	pid := "-"
+	if ev.Pid != 0 {
+		pid = fmt.Sprint(ev.Pid)
+	}
+
+	return fmt.Sprintf(format_sock,
+		fixedWidth(m.formatTime(ev.Timestamp, prevTs), timeWidth), coloredSeparator,
+		fixedWidth(sockKindNames[ev.Kind], sockKindWidth), coloredSeparator,
+		protoStyleCache[ev.Protocol].Render(fixedWidth(protoToString(ev.Protocol), protoWidth)), coloredSeparator,
+		fixedWidth(net.JoinHostPort(ev.IP().String(), fmt.Sprint(ev.Port)), endpointWidth), coloredSeparator,
+		MagentaStyle.Render(fixedWidth(commString(ev.Comm), commWidth)), coloredSeparator,
+		fixedWidth(pid, pidWidth), coloredSeparator,
+		fixedWidth(userName(ev.Uid), userWidth), coloredSeparator,
+		style.Render(fixedWidth(state, connStateWidth)), coloredSeparator,
+		fixedWidth(pkts, packetsCountWidth), coloredSeparator,
+		RedTextSyle.Render(fixedWidth(in, bytesWidth)), coloredSeparator,
+		GreenTextSyle.Render(fixedWidth(out, bytesWidth)),
+	)
+}
+
+// sockFlowState correlates a socket call with the packets that followed
+// it. A bind is matched with the inbound TCP connections to its port; a
+// connect or send with the packets of its own socket.
+func (m *model) sockFlowState(ev SockEvent) (string, lipgloss.Style, sockFlow) {
+	plain := lipgloss.NewStyle()
+	if ev.Kind == sockBind {
+		if ev.Protocol != 6 {
+			return "-", plain, sockFlow{}
+		}
+		var flow sockFlow
+		n := 0
+		addr := ev.IP()
+		for key, c := range m.conns.conns {
+			if key.LocalPort != ev.Port || c.Outbound {
+				continue
+			}
+			if !addr.IsUnspecified() && !bytesToIP(key.Local).Equal(addr) {
+				continue
+			}
+			n++
+			flow.BytesIn += c.BytesIn
+			flow.BytesOut += c.BytesOut
+			flow.Estimated = flow.Estimated || c.Estimated
+		}
+		return fmt.Sprintf("%d conns", n), plain, flow
+	}
+
+	f := m.sockFlows[ev.Cookie]
+	if f == nil || f.Packets == 0 {
+		return "no packets", RedTextSyle, sockFlow{}
+	}
+	if !f.TCP {
+		return "active", plain, *f
+	}
+	if c := m.conns.conns[f.Conn]; c != nil {
+		return c.State, connStateStyle(c.State), *f
+	}
+	return "expired", plain, *f
+}
+
+// matchesSockFilter applies the proto, ip, port and proc filters of the agg
+// view to a socket call.
+func (m *model) matchesSockFilter(ev SockEvent) bool {
+	f := m.filter.aggMode
+	if !m.filter.active || (f == aggFilter{}) {
+		return true
+	}
+	if f.protocol != "" && !protoMatchesFilter(ev.Protocol, f.protocol) {
+		return false
+	}
+	if f.ip != "" && !m.ipMatchesFilter(ev.IP().String(), f.ip) {
+		return false
+	}
+	if f.port != "" && !portMatchesFilter(ev.Port, f.port) {
+		return false
+	}
+	if f.process != "" && !processMatchesFilter(procKey{Pid: ev.Pid, Comm: commString(ev.Comm)}, f.process) {
+		return false
+	}
+	return true
+}
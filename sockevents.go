@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// Kinds of socket calls reported by the cgroup sock_addr and post_bind
+// programs, see sock_event_t in ioNet.c.
+const (
+	sockConnect = 1
+	sockSendmsg = 2
+	sockBind    = 3
+)
+
+var sockKindNames = map[uint8]string{
+	sockConnect: "connect",
+	sockSendmsg: "sendmsg",
+	sockBind:    "bind",
+}
+
+// SockEvent is a connect(), an addressed UDP sendmsg() or a bind(). Addr and
+// Port are the destination, or the bound local address for sockBind.
+type SockEvent struct {
+	Kind      uint8
+	Protocol  uint8
+	Port      uint16
+	Family    uint32
+	Addr      [16]byte
+	Pid       uint32
+	Uid       uint32
+	CgroupID  uint64
+	Cookie    uint64
+	Timestamp uint64 // wall clock, ns
+	AttachID  uint32
+	Comm      [16]byte
+}
+
+// Byte offsets of the fields of the packed struct sock_event_t.
+const (
+	offSockKind       = 0
+	offSockProtocol   = 1
+	offSockPort       = 2
+	offSockFamily     = 4
+	offSockAddr       = 8
+	offSockPid        = 24
+	offSockUid        = 28
+	offSockCgroupID   = 32
+	offSockCookie     = 40
+	offSockTsNs       = 48
+	offSockAttachID   = 56
+	offSockComm       = 60
+	sockEventWireSize = 76
+)
+
+// sockQueueLen bounds the socket calls waiting for the TUI.
+const sockQueueLen = 4096
+
+// decodeSockEvent fills e from a little-endian sock_event_t. Timestamp is
+// left as the kernel's monotonic time.
+func decodeSockEvent(b []byte, e *SockEvent) error {
+	if len(b) < sockEventWireSize {
+		return fmt.Errorf("short sock event: %d bytes, want %d", len(b), sockEventWireSize)
+	}
+	le := binary.LittleEndian
+	e.Kind = b[offSockKind]
+	e.Protocol = b[offSockProtocol]
+	e.Port = le.Uint16(b[offSockPort:])
+	e.Family = le.Uint32(b[offSockFamily:])
+	copy(e.Addr[:], b[offSockAddr:offSockPid])
+	e.Pid = le.Uint32(b[offSockPid:])
+	e.Uid = le.Uint32(b[offSockUid:])
+	e.CgroupID = le.Uint64(b[offSockCgroupID:])
+	e.Cookie = le.Uint64(b[offSockCookie:])
+	e.Timestamp = le.Uint64(b[offSockTsNs:])
+	e.AttachID = le.Uint32(b[offSockAttachID:])
+	copy(e.Comm[:], b[offSockComm:sockEventWireSize])
+	return nil
+}
+
+func (e SockEvent) IP() net.IP {
+	if e.Family == 2 {
+		return net.IP(e.Addr[:4]).To16()
+	}
+	return net.IP(e.Addr[:])
+}
+
+// readSock forwards the socket calls in rd until it is closed. They are
+// rare next to packets, so they skip the batching of the packet path; a
+// full queue drops them.
+func (s *bpfSource) readSock(rd *ringbuf.Reader) {
+	var record ringbuf.Record
+	var ev SockEvent
+	for {
+		if err := rd.ReadInto(&record); err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return
+			}
+			log.Printf("Error reading from %s: %v", bpfMapSock, err)
+			continue
+		}
+		if err := decodeSockEvent(record.RawSample, &ev); err != nil {
+			s.parseErrors.Add(1)
+			continue
+		}
+		ev.Timestamp = s.clock.wall(ev.Timestamp)
+		select {
+		case s.sockEvents <- ev:
+		default:
+			s.sockDropped.Add(1)
+		}
+	}
+}
+
+func (s *bpfSource) SockEvents() <-chan SockEvent { return s.sockEvents }
+
+// SockDropped counts socket calls lost because the TUI fell behind. Nothing
+// reads them outside the TUI, so they are kept out of Stats.
+func (s *bpfSource) SockDropped() uint64 { return s.sockDropped.Load() }
@@ -0,0 +1,65 @@
+package main
+
+import "time"
+
+// EventSource feeds decoded traffic events into the model. The cgroup eBPF
+// loader is one implementation; anything that can produce StructEvent values
+// (replays, synthetic generators, remote collectors) can plug in the same way.
+// Events are delivered in batches, which the receiver owns.
+type EventSource interface {
+	Start() error
+	Stop() error
+	Events() <-chan EventBatch
+	Errors() <-chan error
+	Stats() SourceStats
+}
+
+// EventBatch is a batch of events. Seek is the number of seeks a playback
+// source had applied when the events were read, so batches still queued from
+// before a seek can be told apart; other sources leave it at 0.
+type EventBatch struct {
+	Events []StructEvent
+	Seek   uint64
+}
+
+// SourceStats counts events lost along the way: Dropped in the channel to the
+// TUI, KernelDropped when the eBPF programs could not emit them and
+// ParseErrors for packets or records that could not be decoded.
+type SourceStats struct {
+	Received      uint64
+	Dropped       uint64
+	KernelDropped uint64
+	ParseErrors   uint64
+}
+
+// PlaybackControl is implemented by sources that replay stored data and can
+// be paused, seeked and re-paced from the TUI.
+type PlaybackControl interface {
+	TogglePause()
+	Seek(d time.Duration)
+	SetSpeed(speed float64)
+	PlaybackState() PlaybackState
+}
+
+type PlaybackState struct {
+	Host     string
+	Mode     string // capture mode of the recording
+	Position time.Time
+	Speed    float64
+	Paused   bool
+	Finished bool
+	Seeks    uint64 // seeks applied so far, see EventBatch.Seek
+}
+
+// SockEventSource is implemented by sources that also report the socket
+// calls of the traced tasks: connects, addressed UDP sends and binds.
+type SockEventSource interface {
+	SockEvents() <-chan SockEvent
+	SockDropped() uint64
+}
+
+// Sampler is implemented by sources that can sample packets at capture time.
+type Sampler interface {
+	SetSampleRate(rate uint32) error
+	SampleRate() uint32
+}
@@ -8,9 +8,17 @@ import (
 )
 
 func (m *model) updateAggView() {
+	if m.groupByProcess {
+		m.updateProcView()
+		return
+	}
 	m.aggEventsCount = len(m.aggResults)
 	aggEvents := m.filterAggResults(m.aggResults)
 	rows := m.formatAggregatedData(aggEvents)
+	if m.selectable() {
+		m.selectAggRow()
+		m.highlightRow(rows)
+	}
 
 	content := lipgloss.JoinVertical(lipgloss.Left, rows...)
 
@@ -48,25 +56,98 @@ func (m *model) formatAggregatedData(aggregated map[aggKey]aggVal) []string {
 	})
 
 	var result []string
+	m.aggKeys = m.aggKeys[:0]
 	for _, row := range rows {
+		m.aggKeys = append(m.aggKeys, row.key)
 		IP := bytesToIP(row.key.IP)
 		owner := GetIPOwnerCached(IP)
 
 		formatted := fmt.Sprintf(format_agg,
 			fixedWidth(IP.String(), ipWidth), coloredSeparator,
-			fixedWidth(fmt.Sprint(row.key.Port), portWidth), coloredSeparator,
+			fixedWidth(aggPortString(row.key), portWidth), coloredSeparator,
 			lipgloss.NewStyle().Foreground(protocolColor(protoToString(row.key.Protocol))).Render(
 				fixedWidth(protoToString(row.key.Protocol), protoWidth)), coloredSeparator,
-			MagentaStyle.Render(fixedWidth(fmt.Sprint(row.val.Count), packetsCountWidth)), coloredSeparator,
+			MagentaStyle.Render(fixedWidth(estimate(fmt.Sprint(row.val.Count), row.val.Estimated), packetsCountWidth)), coloredSeparator,
 			RedTextSyle.Render(
-				fixedWidth(parseBytes(row.val.IngressBytes), bytesWidth)), coloredSeparator,
+				fixedWidth(estimate(parseBytes(row.val.IngressBytes), row.val.Estimated), bytesWidth)), coloredSeparator,
 			GreenTextSyle.Render(
-				fixedWidth(parseBytes(row.val.EgressBytes), bytesWidth)), coloredSeparator,
-			fixedWidth(parseBytes(row.val.TotalBytes), bytesWidth), coloredSeparator,
-			fixedWidth(owner, dnsNameWidth),
+				fixedWidth(estimate(parseBytes(row.val.EgressBytes), row.val.Estimated), bytesWidth)), coloredSeparator,
+			fixedWidth(estimate(parseBytes(row.val.TotalBytes), row.val.Estimated), bytesWidth), coloredSeparator,
+			fixedWidth(hostName(IP), dnsNameWidth), coloredSeparator,
+			fixedWidth(aggHostsString(row.key), sniWidth), coloredSeparator,
+			fixedWidth(owner, ownerWidth),
 		)
 		result = append(result, formatted)
 	}
 
 	return result
 }
+
+func (m *model) updateProcView() {
+	m.aggEventsCount = len(m.procResults)
+	rows := m.formatProcessData(m.filterProcResults(m.procResults))
+
+	m.headerView.SetContent(tableHeaderProc)
+	m.viewport.SetContent(lipgloss.JoinVertical(lipgloss.Left, rows...))
+}
+
+func (m *model) formatProcessData(results map[procKey]aggVal) []string {
+	type sortableRow struct {
+		key procKey
+		val aggVal
+	}
+	rows := make([]sortableRow, 0, len(results))
+	for key, val := range results {
+		rows = append(rows, sortableRow{key, val})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].val.TotalBytes != rows[j].val.TotalBytes {
+			return rows[i].val.TotalBytes > rows[j].val.TotalBytes
+		}
+		return rows[i].key.Pid < rows[j].key.Pid
+	})
+
+	var result []string
+	for _, row := range rows {
+		pid := "-"
+		if row.key.Pid != 0 {
+			pid = fmt.Sprint(row.key.Pid)
+		}
+		result = append(result, fmt.Sprintf(format_proc,
+			MagentaStyle.Render(fixedWidth(row.key.String(), commWidth)), coloredSeparator,
+			fixedWidth(pid, pidWidth), coloredSeparator,
+			fixedWidth(userName(row.key.Uid), userWidth), coloredSeparator,
+			fixedWidth(cgroupPathByID(row.key.CgroupID), cgPathWidth), coloredSeparator,
+			MagentaStyle.Render(fixedWidth(estimate(fmt.Sprint(row.val.Count), row.val.Estimated), packetsCountWidth)), coloredSeparator,
+			RedTextSyle.Render(fixedWidth(estimate(parseBytes(row.val.IngressBytes), row.val.Estimated), bytesWidth)), coloredSeparator,
+			GreenTextSyle.Render(fixedWidth(estimate(parseBytes(row.val.EgressBytes), row.val.Estimated), bytesWidth)), coloredSeparator,
+			fixedWidth(estimate(parseBytes(row.val.TotalBytes), row.val.Estimated), bytesWidth),
+		))
+	}
+	return result
+}
+
+// estimate marks values scaled up from sampled packets.
+func estimate(value string, estimated bool) string {
+	if estimated {
+		return "~" + value
+	}
+	return value
+}
+
+// aggHostsString shows the server names seen on a TCP row's connections.
+func aggHostsString(key aggKey) string {
+	if key.Protocol != 6 {
+		return "-"
+	}
+	return hostsString(endpointHostsFor(key.IP, key.Port))
+}
+
+// aggPortString shows the port of an agg row, or the message type for ICMP.
+func aggPortString(key aggKey) string {
+	if isICMP(key.Protocol) {
+		return icmpString(key.Protocol, key.Port)
+	}
+	return fmt.Sprint(key.Port)
+}
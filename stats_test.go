@@ -0,0 +1,132 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+func fields(row string) []string {
+	var out []string
+	for _, f := range strings.Split(ansi.Strip(row), "│") {
+		out = append(out, strings.TrimSpace(f))
+	}
+	return out
+}
+
+func TestFormatAggregatedDataSortsByTotal(t *testing.T) {
+	m := initialModel(newFakeSource())
+	m.addEvents([]StructEvent{
+		event4('o', 17, "10.0.0.2", "10.2.2.2", 5353, 53, 40),
+		event4('i', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 2048),
+		event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100),
+	})
+	rows := m.formatAggregatedData(m.aggResults)
+	if len(rows) != 2 {
+		t.Fatalf("rows = %d, want 2", len(rows))
+	}
+
+	got := fields(rows[0])
+	want := []string{"10.1.1.1", "443", "TCP", "2", "2.00 KB", "100 B", "2.10 KB"}
+	if len(got) < len(want) || !slices.Equal(got[:len(want)], want) {
+		t.Errorf("first row = %q, want prefix %q", got, want)
+	}
+	if got := fields(rows[1]); got[0] != "10.2.2.2" || got[1] != "53" {
+		t.Errorf("second row = %q, want 10.2.2.2:53", got)
+	}
+	if m.aggKeys[0] != aggKeyFor("10.1.1.1", 443, 6) {
+		t.Errorf("aggKeys not in row order: %v", m.aggKeys)
+	}
+}
+
+func TestFormatAggregatedDataHidesLocal(t *testing.T) {
+	m := initialModel(newFakeSource())
+	m.addEvents([]StructEvent{event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100)})
+	m.showLocal = false
+	if rows := m.formatAggregatedData(m.aggResults); len(rows) != 0 {
+		t.Errorf("local rows shown with showLocal off: %q", rows)
+	}
+}
+
+func TestRenderEventLine(t *testing.T) {
+	m := initialModel(newFakeSource())
+	ev := event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 1234)
+	got := fields(m.renderEventLine(ev, 0))
+	if got[1] != "TCP" || got[5] != "10.0.0.2:40000" || got[6] != "10.1.1.1:443" || got[9] != "1234" {
+		t.Errorf("row = %q", got)
+	}
+
+	m.showLocal = false
+	if line := m.renderEventLine(ev, 0); line != "" {
+		t.Errorf("local event rendered with showLocal off: %q", line)
+	}
+}
+
+func TestUpdateRawViewAppliesFilter(t *testing.T) {
+	m := initialModel(newFakeSource())
+	m.addEvents([]StructEvent{
+		event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100),
+		event4('o', 17, "10.0.0.2", "10.2.2.2", 5353, 53, 40),
+	})
+	m.filter.active = true
+	m.filter.rawMode = rawFilter{protocol: "udp"}
+	m.viewport.Height = 10
+	m.updateRawView()
+
+	lines := strings.Split(strings.TrimSpace(ansi.Strip(m.viewport.View())), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "10.2.2.2:53") {
+		t.Errorf("filtered raw view = %q, want only the UDP event", lines)
+	}
+}
+
+func TestApplyAggFilterRejectsProcessAndState(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		byProc  bool
+		text    string
+		applied bool
+	}{
+		{"address rows", false, "port=443", true},
+		{"proc on address rows", false, "port=443 proc=curl", false},
+		{"state on address rows", false, "state=refused", false},
+		{"proc on process rows", true, "proc=curl", true},
+	} {
+		m := initialModel(newFakeSource())
+		m.currentView = "agg"
+		m.groupByProcess = tt.byProc
+		m.applyAggFilter(tt.text)
+		if applied := m.filter.aggMode != (aggFilter{}); applied != tt.applied || m.isError == tt.applied {
+			t.Errorf("%s: applied %v, error %v %q; want applied %v", tt.name, applied, m.isError, m.message, tt.applied)
+		}
+	}
+}
+
+func TestFormatProcessData(t *testing.T) {
+	ev := event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100)
+	ev.key.Pid = 42
+	copy(ev.key.Comm[:], "curl")
+	m := initialModel(newFakeSource())
+	m.addEvents([]StructEvent{ev, ev})
+
+	rows := m.formatProcessData(m.procResults)
+	if len(rows) != 1 {
+		t.Fatalf("rows = %d, want 1", len(rows))
+	}
+	got := fields(rows[0])
+	if got[0] != "curl" || got[1] != "42" || got[4] != "2" || got[6] != "200 B" {
+		t.Errorf("row = %q, want curl, pid 42, 2 packets, 200 B out", got)
+	}
+}
+
+func TestFormatAggregatedDataMarksEstimates(t *testing.T) {
+	m := initialModel(newFakeSource())
+	ev := event4('i', 17, "10.0.0.2", "10.3.3.3", 5000, 6000, 100)
+	ev.key.SampleRate = 4
+	m.addEvents([]StructEvent{ev})
+	got := fields(m.formatAggregatedData(m.aggResults)[0])
+	if got[3] != "~4" || got[4] != "~400 B" {
+		t.Errorf("row = %q, want ~4 packets and ~400 B in", got)
+	}
+}
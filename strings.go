@@ -3,14 +3,46 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
-var views = []string{"raw", "agg"}
+var views = []string{"raw", "agg", "conn", "sock", "deny"}
 
-const format_row = "%-8s%s%-8s%s%-3s%s%8s%s %-45s %s %-45s %s%-12s%s%-10s%s%-9s"
-const format_agg = "%-45s%s%-5s%s%-8s%s%-8s%s%12s%s%12s%s%12s%s%30s"
+const format_row = "%-15s%s%-8s%s%-3s%s%8s%s%-10s%s %-45s %s %-45s %s%-30s%s%-30s%s%-12s%s%-10s%s%-9s%s%-15s%s%7s%s%-8s"
+const format_agg = "%-45s%s%-17s%s%-8s%s%-8s%s%12s%s%12s%s%12s%s%-30s%s%-30s%s%-30s"
+const format_conn = "%-45s%s%-45s%s%-3s%s%-11s%s%10s%s%12s%s%12s%s%-30s"
+const format_sock = "%-15s%s%-8s%s%-8s%s%-45s%s%-15s%s%7s%s%-8s%s%-11s%s%8s%s%12s%s%12s"
+const format_proc = "%-15s%s%7s%s%-8s%s%-40s%s%-8s%s%12s%s%12s%s%12s"
+const format_deny = "%6s%s%-6s%s%-45s%s%-6s%s%-30s%s%12s%s%12s%s%-10s%s%8s%s%12s"
 
 const maxRows = 3000
+
+const maxBatchesPerTick = 256
+
+const maxSampleRate = 1 << 16
+
+// Raw mode ring buffer size; the default matches traffic_ring in ioNet.c.
+const (
+	defaultRingSize = 16 * MB
+	maxRingSize     = 1 * GB
+)
+
+// Time column modes of the raw view, cycled with "t".
+const (
+	timeSeconds = iota
+	timeMillis
+	timeMicros
+	timeDelta
+	timeModeCount
+)
+
+var timeModeNames = [timeModeCount]string{"s", "ms", "µs", "delta"}
+
+const (
+	playSeekStep = 10 * time.Second
+	minPlaySpeed = 1.0 / 64
+	maxPlaySpeed = 1024
+)
 const (
 	DIRECTION_INGRESS = "🠃🠃🠃"
 	DIRECTION_EGRESS  = "🠑🠑🠑"
@@ -43,19 +75,35 @@ const (
 
 const (
 	packetsCountWidth = 8
-	portWidth         = 5
+	portWidth         = 17
 	dnsNameWidth      = 30
+	ownerWidth        = 30
+	sniWidth          = 30
 	ipWidth           = 45
 
-	timeWidth    = 8
+	timeWidth    = 15
 	protoWidth   = 8
 	dirWidth     = 3
 	ifWidth      = 8
+	cgroupWidth  = 10
 	srcWidth     = 45
 	dstWidth     = 45
 	bytesWidth   = 12
 	typeWidth    = 10
 	pktTypeWidth = 9
+	commWidth    = 15
+	pidWidth     = 7
+	userWidth    = 8
+	cgPathWidth  = 40
+
+	endpointWidth  = 45
+	connDirWidth   = 3
+	connStateWidth = 11
+	durationWidth  = 10
+	sockKindWidth  = 8
+	denyIDWidth    = 6
+	denyPortWidth  = 6
+	denyKindWidth  = 6
 )
 
 var tableHeader = fmt.Sprintf(
@@ -64,11 +112,17 @@ var tableHeader = fmt.Sprintf(
 	"Proto", coloredSeparator,
 	"Dir", coloredSeparator,
 	"IF", coloredSeparator,
+	"CGROUP", coloredSeparator,
 	"Source", coloredSeparator,
 	"Destination", coloredSeparator,
+	"HOST", coloredSeparator,
+	"SNI", coloredSeparator,
 	"Bytes", coloredSeparator,
 	"Type", coloredSeparator,
-	"Pkttype",
+	"Pkttype", coloredSeparator,
+	"PROCESS", coloredSeparator,
+	"PID", coloredSeparator,
+	"USER",
 )
 
 var tableHeaderAgg = fmt.Sprintf(
@@ -80,9 +134,146 @@ var tableHeaderAgg = fmt.Sprintf(
 	"INGRESS", coloredSeparator,
 	"EGRESS", coloredSeparator,
 	"TOTAL", coloredSeparator,
-	"DNS_NAME",
+	"DNS_NAME", coloredSeparator,
+	"SNI", coloredSeparator,
+	"OWNER",
+)
+
+var tableHeaderProc = fmt.Sprintf(
+	format_proc,
+	"PROCESS", coloredSeparator,
+	"PID", coloredSeparator,
+	"USER", coloredSeparator,
+	"CGROUP", coloredSeparator,
+	"COUNT", coloredSeparator,
+	"INGRESS", coloredSeparator,
+	"EGRESS", coloredSeparator,
+	"TOTAL",
+)
+
+var tableHeaderConn = fmt.Sprintf(
+	format_conn,
+	"LOCAL", coloredSeparator,
+	"REMOTE", coloredSeparator,
+	"DIR", coloredSeparator,
+	"STATE", coloredSeparator,
+	"DURATION", coloredSeparator,
+	"IN", coloredSeparator,
+	"OUT", coloredSeparator,
+	"SNI",
+)
+
+var tableHeaderSock = fmt.Sprintf(
+	format_sock,
+	"Time", coloredSeparator,
+	"CALL", coloredSeparator,
+	"Proto", coloredSeparator,
+	"ADDRESS", coloredSeparator,
+	"PROCESS", coloredSeparator,
+	"PID", coloredSeparator,
+	"USER", coloredSeparator,
+	"FLOW", coloredSeparator,
+	"PKTS", coloredSeparator,
+	"IN", coloredSeparator,
+	"OUT",
 )
 
+var tableHeaderDeny = fmt.Sprintf(
+	format_deny,
+	"ID", coloredSeparator,
+	"ACTION", coloredSeparator,
+	"TARGET", coloredSeparator,
+	"PORT", coloredSeparator,
+	"DNS_NAME", coloredSeparator,
+	"LIMIT", coloredSeparator,
+	"RATE", coloredSeparator,
+	"EXPIRES", coloredSeparator,
+	"DROPPED", coloredSeparator,
+	"BYTES",
+)
+
+var separator_deny = strings.Join([]string{
+	strings.Repeat(coloredLine, denyIDWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, denyKindWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, endpointWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, denyPortWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, dnsNameWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, durationWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, packetsCountWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+}, "")
+
+var separator_sock = strings.Join([]string{
+	strings.Repeat(coloredLine, timeWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, sockKindWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, protoWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, endpointWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, commWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, pidWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, userWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, connStateWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, packetsCountWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+}, "")
+
+var separator_conn = strings.Join([]string{
+	strings.Repeat(coloredLine, endpointWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, endpointWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, connDirWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, connStateWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, durationWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, sniWidth),
+}, "")
+
+var separator_proc = strings.Join([]string{
+	strings.Repeat(coloredLine, commWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, pidWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, userWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, cgPathWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, packetsCountWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, bytesWidth),
+}, "")
+
 var separator_agg = strings.Join([]string{
 	strings.Repeat(coloredLine, ipWidth),
 	coloredCross,
@@ -99,6 +290,10 @@ var separator_agg = strings.Join([]string{
 	strings.Repeat(coloredLine, bytesWidth),
 	coloredCross,
 	strings.Repeat(coloredLine, dnsNameWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, sniWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, ownerWidth),
 }, "")
 
 var separator = strings.Join([]string{
@@ -110,15 +305,25 @@ var separator = strings.Join([]string{
 	coloredCross,
 	strings.Repeat(coloredLine, ifWidth),
 	coloredCross,
+	strings.Repeat(coloredLine, cgroupWidth),
+	coloredCross,
 	strings.Repeat(coloredLine, srcWidth+2), //+padding
 	coloredCross,
 	strings.Repeat(coloredLine, dstWidth+2),
 	coloredCross,
+	strings.Repeat(coloredLine, dnsNameWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, sniWidth),
+	coloredCross,
 	strings.Repeat(coloredLine, bytesWidth),
 	coloredCross,
 	strings.Repeat(coloredLine, typeWidth),
 	coloredCross,
 	strings.Repeat(coloredLine, pktTypeWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, commWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, pidWidth),
+	coloredCross,
+	strings.Repeat(coloredLine, userWidth),
 }, "")
-
-const ERR_CHAN = "full_chan"
@@ -45,6 +45,8 @@ var (
 	GreenTextSyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FF00"))
 
 	TypeStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+
+	selectedStyle = lipgloss.NewStyle().Reverse(true)
 )
 
 func directionColor(direction string) lipgloss.Color {
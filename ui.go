@@ -11,8 +11,16 @@ func (m *model) View() string {
 	viewportContent := m.viewport.View()
 	footer := m.renderFooter()
 	sep := ""
-	if m.currentView == "agg" {
+	if m.currentView == "agg" && m.groupByProcess {
+		sep = separator_proc
+	} else if m.currentView == "agg" {
 		sep = separator_agg
+	} else if m.currentView == "conn" {
+		sep = separator_conn
+	} else if m.currentView == "sock" {
+		sep = separator_sock
+	} else if m.currentView == "deny" {
+		sep = separator_deny
 	} else {
 		sep = separator
 	}
@@ -26,6 +34,16 @@ func (m *model) View() string {
 		),
 	)
 
+	if m.palette.active {
+		return lipgloss.JoinVertical(
+			lipgloss.Left,
+			header,
+			fullTable,
+			lipgloss.NewStyle().Width(m.width-4).MarginTop(1).Render(m.palette.input.View()),
+			footer,
+		)
+	}
+
 	if m.filter.active {
 		inputField := lipgloss.NewStyle().
 			Width(m.width - 4).
@@ -50,10 +68,55 @@ func (m *model) View() string {
 }
 
 func (m *model) renderHeader() string {
-	return headerStyle.Render(fmt.Sprintf(
+	header := fmt.Sprintf(
 		"Network Monitor | Filter : %v | %d events - %d aggregate | Mode: %s | Auto-scroll: %v | ShowLocal: %v",
 		m.filter, len(m.rawEvents), m.aggEventsCount, m.currentView, m.autoScroll, m.showLocal,
-	))
+	)
+	if m.currentView == "conn" {
+		header += fmt.Sprintf(" | Conns: %d refused: %d reset: %d timed out: %d",
+			len(m.conns.conns), m.conns.refused, m.conns.resets, m.conns.timeouts)
+	}
+	if ss, ok := m.source.(SockEventSource); ok && m.currentView == "sock" {
+		header += fmt.Sprintf(" | Socket calls: %d dropped: %d", len(m.sockEvents), ss.SockDropped())
+	}
+	if m.enforcer() != nil {
+		header += fmt.Sprintf(" | Enforcing: %d rules", len(m.denyRules))
+	}
+	if m.filter.active && m.filter.rawMode != (rawFilter{}) {
+		header += " | Filtering in " + m.filter.where
+	}
+	if m.filter.where != filterUserspace {
+		header += " | KERNEL-FILTERED: all views and totals only count matching packets"
+	}
+	stats := m.source.Stats()
+	header += fmt.Sprintf(" | Drops kernel: %d chan: %d (%.0f/s) | Parse errors: %d",
+		stats.KernelDropped, stats.Dropped, m.drops.rate, stats.ParseErrors)
+	if sampler, ok := m.source.(Sampler); ok && sampler.SampleRate() > 1 {
+		header += fmt.Sprintf(" | Sampling 1/%d", sampler.SampleRate())
+	}
+	if ctl, ok := m.source.(PlaybackControl); ok {
+		header += " | " + renderPlayback(ctl.PlaybackState())
+	}
+	return headerStyle.Render(header)
+}
+
+func renderPlayback(st PlaybackState) string {
+	state := "playing"
+	switch {
+	case st.Paused:
+		state = "paused"
+	case st.Finished:
+		state = "end"
+	}
+	speed := "max"
+	if st.Speed > 0 {
+		speed = fmt.Sprintf("x%g", st.Speed)
+	}
+	host := st.Host
+	if st.Mode != "" {
+		host += " (" + st.Mode + ")"
+	}
+	return fmt.Sprintf("Play %s %s %s %s", host, st.Position.Format("2006-01-02 15:04:05.000"), speed, state)
 }
 
 func (m *model) renderFooter() string {
@@ -64,8 +127,8 @@ func (m *model) renderFooter() string {
 		return footerStyle.Render(m.message)
 	}
 	return footerStyle.Render(fmt.Sprintf(
-		"Scroll pos: %d | Ctrl+C: quit | tab: toggle mode | ↑/↓: scroll | a: auto-scroll | l: show local | e %d",
-		m.viewport.YOffset, len(m.events),
+		"Scroll pos: %d | Ctrl+C: quit | tab: toggle mode | ↑/↓: scroll | a: auto-scroll | l: show local | g: group by process | t: time (%s) | f: filter (esc closes) | e %d%s",
+		m.viewport.YOffset, timeModeNames[m.timeMode], len(m.source.Events()), m.playbackHelp()+m.sampleHelp()+m.reloadHelp()+m.denyHelp(),
 	))
 }
 
@@ -73,3 +136,31 @@ func (m *model) setMessage(msg string, isError bool) {
 	m.message = msg
 	m.isError = isError
 }
+
+func (m *model) playbackHelp() string {
+	if _, ok := m.source.(PlaybackControl); !ok {
+		return ""
+	}
+	return " | space: pause | ←/→: seek | [/]: speed"
+}
+
+func (m *model) sampleHelp() string {
+	if _, ok := m.source.(Sampler); !ok {
+		return ""
+	}
+	return " | +/-: sample rate"
+}
+
+func (m *model) denyHelp() string {
+	if m.enforcer() == nil {
+		return ""
+	}
+	return " | x/r: block/limit row (agg) | u: remove rule (deny)"
+}
+
+func (m *model) reloadHelp() string {
+	if _, ok := m.source.(Reconfigurable); !ok {
+		return ""
+	}
+	return " | :: reconfigure"
+}
@@ -1,33 +1,72 @@
 package main
 
 import (
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-func (m *model) addEvent(ev StructEvent) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.rawEvents = append(m.rawEvents, ev)
-	if len(m.rawEvents) > maxRows {
-		m.rawEvents = m.rawEvents[1:]
+// addBatch ingests a batch from the source, dropping it if it was read before
+// the latest playback seek.
+func (m *model) addBatch(batch EventBatch) {
+	if m.syncSeek(batch.Seek) {
+		m.addEvents(batch.Events)
 	}
 }
 
-func (m *model) aggregateEvent(ev StructEvent) {
+// syncSeek clears the views once a playback source has applied a seek, so
+// they only show data from the new position, and reports whether data read
+// after seek number seek is current.
+func (m *model) syncSeek(seek uint64) bool {
+	if seek < m.seek {
+		return false
+	}
+	if seek > m.seek {
+		m.seek = seek
+		m.resetData()
+	}
+	return true
+}
+
+// addEvents ingests one batch from the source under a single lock.
+// Process lookups may touch /proc, so they are resolved before locking.
+func (m *model) addEvents(batch []StructEvent) {
+	procs := m.procScratch[:0]
+	for _, ev := range batch {
+		procs = append(procs, processOf(ev))
+	}
+	m.procScratch = procs
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.rawEvents = append(m.rawEvents, batch...)
+	if over := len(m.rawEvents) - maxRows; over > 0 {
+		n := copy(m.rawEvents, m.rawEvents[over:])
+		m.rawEvents = m.rawEvents[:n]
+	}
+	for i, ev := range batch {
+		m.aggregateEvent(ev, procs[i])
+	}
+	m.conns.expire()
+}
+
+// aggregateEvent must be called with m.mu held.
+func (m *model) aggregateEvent(ev StructEvent, proc procKey) {
 	var ingressBytes, egressBytes uint64
 	ip, port := getIPPort(ev)
 
+	// Sampled events stand for SampleRate packets each.
+	scale := uint64(max(ev.key.SampleRate, 1))
+	packets := int(ev.val.Packets * scale)
 	if ev.key.Direction == 'i' {
-		ingressBytes = ev.val.Bytes
+		ingressBytes = ev.val.Bytes * scale
 		egressBytes = 0
 	} else {
 		ingressBytes = 0
-		egressBytes = ev.val.Bytes
+		egressBytes = ev.val.Bytes * scale
 	}
 	key := aggKey{
 		IP:       ip16ToBytes(ip),
@@ -40,47 +79,101 @@ func (m *model) aggregateEvent(ev StructEvent) {
 	}
 
 	val := m.aggResults[key]
-	val.Count++
+	val.Count += packets
 	val.IngressBytes += ingressBytes
 	val.EgressBytes += egressBytes
+	val.Estimated = val.Estimated || scale > 1
 	val.IsLocal = isLocalIP(ip)
 	val.TotalBytes = val.IngressBytes + val.EgressBytes
 	m.aggResults[key] = val
+
+	pval := m.procResults[proc]
+	pval.Count += packets
+	pval.IngressBytes += ingressBytes
+	pval.EgressBytes += egressBytes
+	pval.Estimated = pval.Estimated || scale > 1
+	pval.TotalBytes = pval.IngressBytes + pval.EgressBytes
+	m.procResults[proc] = pval
+
+	m.conns.observe(ev)
+	m.observeSockFlow(ev)
+}
+
+func (m *model) resetData() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rawEvents = m.rawEvents[:0]
+	m.aggResults = make(map[aggKey]aggVal)
+	m.procResults = make(map[procKey]aggVal)
+	m.conns = newConnTracker()
+	m.sockEvents = m.sockEvents[:0]
+	m.sockFlows = make(map[uint64]*sockFlow)
 }
 
 func (m *model) updateViewportContent() {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	if m.currentView == "raw" {
+	switch m.currentView {
+	case "raw":
 		m.updateRawView()
-	} else {
+	case "conn":
+		m.updateConnView()
+	case "sock":
+		m.updateSockView()
+	case "deny":
+		m.updateDenyView()
+	default:
 		m.updateAggView()
 	}
 }
 
+// processAvailableEvents drains queued batches, stopping after
+// maxBatchesPerTick so a backlog cannot stall rendering; the rest waits for
+// the next tick.
 func (m *model) processAvailableEvents() {
-	for {
+	if ctl, ok := m.source.(PlaybackControl); ok {
+		m.syncSeek(ctl.PlaybackState().Seeks)
+	}
+	if ss, ok := m.source.(SockEventSource); ok {
+		m.drainSockEvents(ss.SockEvents())
+	}
+	for range maxBatchesPerTick {
 		select {
-		case ev := <-m.events:
-			m.addEvent(ev)
-			m.aggregateEvent(ev)
+		case batch, ok := <-m.source.Events():
+			if !ok {
+				return
+			}
+			m.addBatch(batch)
 		default:
 			return
 		}
 	}
 }
 
+type eventBatchMsg EventBatch
+
 func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		return m.handleKeyMsg(msg)
 	case tea.WindowSizeMsg:
 		m.handleWindowSize(msg)
+	case eventBatchMsg:
+		m.addBatch(EventBatch(msg))
+		return m, m.streamEvents()
+	case reloadRequestMsg:
+		return m, m.reloadCmd(nil)
+	case reloadDoneMsg:
+		m.handleReloadDone(msg)
 	case tickRenderMsg:
 		m.processAvailableEvents()
+		m.drops.update(m.source.Stats().Dropped, time.Now())
+		m.refreshDenyRules(time.Now())
 		m.updateViewportContent()
 		if m.autoScroll {
 			m.viewport.GotoBottom()
+		} else if m.selectable() {
+			m.showSelection()
 		}
 		return m, tea.Tick(time.Second/30, func(time.Time) tea.Msg { return tickRenderMsg{} })
 	}
@@ -93,8 +186,11 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m *model) streamEvents() tea.Cmd {
 	return func() tea.Msg {
 		select {
-		case ev := <-m.events:
-			return ev
+		case batch, ok := <-m.source.Events():
+			if !ok {
+				return nil
+			}
+			return eventBatchMsg(batch)
 		case <-time.After(50 * time.Millisecond):
 			return nil
 		}
@@ -102,16 +198,44 @@ func (m *model) streamEvents() tea.Cmd {
 }
 
 func (m *model) toggleView() {
-	for i, v := range views {
-		if v == m.currentView {
-			m.currentView = views[(i+1)%len(views)]
-			return
+	i := slices.Index(views, m.currentView)
+	for {
+		i = (i + 1) % len(views)
+		if m.hasView(views[i]) {
+			break
 		}
 	}
-	m.currentView = views[0]
+	m.currentView = views[i]
+
+	// The kernel only evaluates the filter of the raw view.
+	if m.filter.active {
+		m.pushKernelFilter()
+	} else if m.filter.where != filterUserspace {
+		m.clearKernelFilter()
+	}
+}
+
+// hasView reports whether the source can fill a view: only sources that see
+// socket calls have a sock view, and only enforcing ones a deny view.
+func (m *model) hasView(view string) bool {
+	switch view {
+	case "sock":
+		_, ok := m.source.(SockEventSource)
+		return ok
+	case "deny":
+		return m.enforcer() != nil
+	}
+	return true
 }
 
 func (m *model) handleKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.filter.active {
+		return m.handleFilterKey(msg)
+	}
+	if m.palette.active {
+		return m.handlePaletteKey(msg)
+	}
+
 	switch msg.String() {
 	case "tab":
 		m.toggleView()
@@ -119,10 +243,18 @@ func (m *model) handleKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, tea.Quit
 	case "up":
 		m.autoScroll = false
-		m.viewport.ScrollUp(1)
+		if m.selectable() {
+			m.moveSelection(-1)
+		} else {
+			m.viewport.ScrollUp(1)
+		}
 	case "down":
 		m.autoScroll = false
-		m.viewport.ScrollDown(1)
+		if m.selectable() {
+			m.moveSelection(1)
+		} else {
+			m.viewport.ScrollDown(1)
+		}
 	case "a":
 		m.autoScroll = !m.autoScroll
 		m.viewport.GotoBottom()
@@ -130,30 +262,106 @@ func (m *model) handleKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "l":
 		m.showLocal = !m.showLocal
 
-	case "f":
-		m.filter.active = !m.filter.active
-		if m.filter.active {
-			m.filter.input.Focus()
-			return m, tea.Batch(
-				tea.Printf("Filter mode activated"),
-				textinput.Blink,
-			)
+	case "g":
+		m.groupByProcess = !m.groupByProcess
+
+	case "t":
+		m.timeMode = (m.timeMode + 1) % timeModeCount
+
+	case ":":
+		return m, m.openPalette()
+
+	case "x":
+		if m.selectable() && m.currentView == "agg" {
+			m.blockSelected()
+			return m, textinput.Blink
 		}
-	case "enter":
-		if m.filter.active {
-			return m, m.applyFilter()
+
+	case "r":
+		if m.selectable() && m.currentView == "agg" {
+			m.limitSelected()
+			return m, textinput.Blink
 		}
+
+	case "u":
+		if m.selectable() && m.currentView == "deny" {
+			m.unblockSelected()
+		}
+
+	case "f":
+		m.filter.active = true
+		m.filter.input.Focus()
+		if m.filter.rawMode != (rawFilter{}) {
+			m.pushKernelFilter()
+		}
+		return m, tea.Batch(
+			tea.Printf("Filter mode activated"),
+			textinput.Blink,
+		)
 	}
 
-	if m.filter.active {
-		var cmd tea.Cmd
-		m.filter.input, cmd = m.filter.input.Update(msg)
-		return m, cmd
+	if ctl, ok := m.source.(PlaybackControl); ok {
+		m.handlePlaybackKey(ctl, msg)
+	}
+	if sampler, ok := m.source.(Sampler); ok {
+		m.handleSampleKey(sampler, msg)
 	}
 
 	return m, nil
 }
 
+func (m *model) handleSampleKey(sampler Sampler, msg tea.KeyMsg) {
+	rate := sampler.SampleRate()
+	switch msg.String() {
+	case "+":
+		rate = min(rate*2, maxSampleRate)
+	case "-":
+		rate = max(rate/2, 1)
+	default:
+		return
+	}
+	if err := sampler.SetSampleRate(rate); err != nil {
+		m.setMessage(fmt.Sprintf("sample rate: %v", err), true)
+	}
+}
+
+func (m *model) handleFilterKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.filter.active = false
+		m.filter.input.Blur()
+		m.clearKernelFilter()
+		return m, nil
+	case "enter":
+		return m, m.applyFilter()
+	}
+
+	var cmd tea.Cmd
+	m.filter.input, cmd = m.filter.input.Update(msg)
+	return m, cmd
+}
+
+func (m *model) handlePlaybackKey(ctl PlaybackControl, msg tea.KeyMsg) {
+	switch msg.String() {
+	case " ":
+		ctl.TogglePause()
+	case "left":
+		ctl.Seek(-playSeekStep)
+	case "right":
+		ctl.Seek(playSeekStep)
+	case "[":
+		if speed := ctl.PlaybackState().Speed; speed > minPlaySpeed {
+			ctl.SetSpeed(speed / 2)
+		}
+	case "]":
+		if speed := ctl.PlaybackState().Speed; speed > 0 && speed < maxPlaySpeed {
+			ctl.SetSpeed(speed * 2)
+		}
+	}
+}
+
 func (m *model) handleWindowSize(msg tea.WindowSizeMsg) {
 	m.width = msg.Width
 	m.height = msg.Height
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// fakeSource is an EventSource fed by the test.
+type fakeSource struct {
+	events chan EventBatch
+	errs   chan error
+	stats  SourceStats
+}
+
+func newFakeSource(batches ...[]StructEvent) *fakeSource {
+	s := &fakeSource{
+		events: make(chan EventBatch, len(batches)),
+		errs:   make(chan error),
+	}
+	for _, b := range batches {
+		s.events <- EventBatch{Events: b}
+	}
+	return s
+}
+
+func (s *fakeSource) Start() error              { return nil }
+func (s *fakeSource) Stop() error               { close(s.events); return nil }
+func (s *fakeSource) Events() <-chan EventBatch { return s.events }
+func (s *fakeSource) Errors() <-chan error      { return s.errs }
+func (s *fakeSource) Stats() SourceStats        { return s.stats }
+
+// event4 builds an IPv4 event between local and remote, seen in direction dir.
+func event4(dir byte, proto uint8, local, remote string, localPort, remotePort uint16, bytes uint64) StructEvent {
+	l := binary.LittleEndian.Uint32(net.ParseIP(local).To4())
+	r := binary.LittleEndian.Uint32(net.ParseIP(remote).To4())
+	key := KeyEvent{Protocol: proto, Direction: dir, Family: 2}
+	if dir == 'o' {
+		key.Saddr, key.Daddr, key.Sport, key.Dport = l, r, localPort, remotePort
+	} else {
+		key.Saddr, key.Daddr, key.Sport, key.Dport = r, l, remotePort, localPort
+	}
+	return StructEvent{key: key, val: Stats{Bytes: bytes, Packets: 1}}
+}
+
+func aggKeyFor(ip string, port uint16, proto uint8) aggKey {
+	return aggKey{IP: ip16ToBytes(net.ParseIP(ip)), Port: port, Protocol: proto}
+}
+
+func TestAddEventsAggregatesByRemoteEndpoint(t *testing.T) {
+	src := newFakeSource(
+		[]StructEvent{
+			event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100),
+			event4('i', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 1500),
+		},
+		[]StructEvent{
+			event4('o', 6, "10.0.0.2", "10.1.1.1", 40001, 443, 60),
+			event4('o', 17, "10.0.0.2", "10.2.2.2", 5353, 53, 40),
+		},
+	)
+	m := initialModel(src)
+	m.processAvailableEvents()
+
+	if got := len(m.rawEvents); got != 4 {
+		t.Fatalf("raw events = %d, want 4", got)
+	}
+	if got := len(m.aggResults); got != 2 {
+		t.Fatalf("agg rows = %d, want 2: %v", got, m.aggResults)
+	}
+	want := aggVal{Count: 3, IngressBytes: 1500, EgressBytes: 160, TotalBytes: 1660, IsLocal: true}
+	if got := m.aggResults[aggKeyFor("10.1.1.1", 443, 6)]; got != want {
+		t.Errorf("tcp row = %+v, want %+v", got, want)
+	}
+	want = aggVal{Count: 1, EgressBytes: 40, TotalBytes: 40, IsLocal: true}
+	if got := m.aggResults[aggKeyFor("10.2.2.2", 53, 17)]; got != want {
+		t.Errorf("udp row = %+v, want %+v", got, want)
+	}
+}
+
+func TestAddEventsKeepsLastMaxRows(t *testing.T) {
+	m := initialModel(newFakeSource())
+	batch := make([]StructEvent, maxRows+10)
+	for i := range batch {
+		batch[i] = event4('o', 17, "10.0.0.2", "10.2.2.2", 5353, 53, uint64(i))
+	}
+	m.addEvents(batch)
+
+	if got := len(m.rawEvents); got != maxRows {
+		t.Fatalf("raw events = %d, want %d", got, maxRows)
+	}
+	if got := m.rawEvents[0].val.Bytes; got != 10 {
+		t.Errorf("oldest kept event = %d, want 10", got)
+	}
+	if got := m.aggResults[aggKeyFor("10.2.2.2", 53, 17)].Count; got != maxRows+10 {
+		t.Errorf("agg count = %d, want every event counted", got)
+	}
+}
+
+func TestResetDataClearsViews(t *testing.T) {
+	m := initialModel(newFakeSource())
+	m.addEvents([]StructEvent{event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100)})
+	m.resetData()
+	if len(m.rawEvents) != 0 || len(m.aggResults) != 0 || len(m.procResults) != 0 {
+		t.Errorf("data left after reset: %d raw, %d agg, %d proc", len(m.rawEvents), len(m.aggResults), len(m.procResults))
+	}
+}
+
+func TestAggregateEventGroupsProcesses(t *testing.T) {
+	a := event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100)
+	a.key.Pid, a.key.Uid = 42, 1000
+	copy(a.key.Comm[:], "curl")
+	b := event4('o', 6, "10.0.0.2", "10.1.1.2", 40002, 443, 300)
+	b.key.Pid, b.key.Uid = 42, 1000
+	copy(b.key.Comm[:], "curl")
+	c := event4('o', 17, "10.0.0.2", "10.2.2.2", 5353, 53, 50)
+	c.key.Pid = 7
+	copy(c.key.Comm[:], "resolved")
+
+	m := initialModel(newFakeSource())
+	m.addEvents([]StructEvent{a, b, c})
+
+	if got := len(m.procResults); got != 2 {
+		t.Fatalf("proc rows = %d, want 2: %v", got, m.procResults)
+	}
+	curl := m.procResults[procKey{Pid: 42, Comm: "curl", Uid: 1000}]
+	if curl.Count != 2 || curl.EgressBytes != 400 {
+		t.Errorf("curl = %+v, want 2 packets, 400 bytes out", curl)
+	}
+}
+
+func TestAggregateEventScalesSampledEvents(t *testing.T) {
+	m := initialModel(newFakeSource())
+	ev := event4('i', 17, "10.0.0.2", "10.3.3.3", 5000, 6000, 100)
+	ev.key.SampleRate = 10
+	m.addEvents([]StructEvent{ev})
+
+	want := aggVal{Count: 10, IngressBytes: 1000, TotalBytes: 1000, IsLocal: true, Estimated: true}
+	if got := m.aggResults[aggKeyFor("10.3.3.3", 6000, 17)]; got != want {
+		t.Errorf("sampled row = %+v, want %+v", got, want)
+	}
+	var proc aggVal
+	for _, v := range m.procResults {
+		proc = v
+	}
+	if proc.Count != 10 || proc.TotalBytes != 1000 || !proc.Estimated {
+		t.Errorf("proc row = %+v, want 10 packets of 1000 bytes, estimated", proc)
+	}
+}
+
+func TestAddBatchDropsBatchesFromBeforeSeek(t *testing.T) {
+	before := event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100)
+	after := event4('o', 6, "10.0.0.2", "10.1.1.2", 40000, 443, 200)
+	m := initialModel(newFakeSource())
+
+	m.addBatch(EventBatch{Events: []StructEvent{before}})
+	m.addBatch(EventBatch{Events: []StructEvent{after}, Seek: 1})
+	m.addBatch(EventBatch{Events: []StructEvent{before}})
+
+	if len(m.rawEvents) != 1 || m.rawEvents[0] != after {
+		t.Errorf("raw events = %+v, want only the event read after the seek", m.rawEvents)
+	}
+	if _, ok := m.aggResults[aggKeyFor("10.1.1.1", 443, 6)]; ok {
+		t.Error("agg row from before the seek kept")
+	}
+}
+
+func TestEventSinkStampsSeeks(t *testing.T) {
+	q := newEventSink(defaultQueueBudget, make(chan struct{}), dropNever, 0)
+	q.add(event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100))
+	q.flush()
+	q.add(event4('o', 6, "10.0.0.2", "10.1.1.1", 40000, 443, 100))
+	q.reset()
+	q.add(event4('o', 6, "10.0.0.2", "10.1.1.2", 40000, 443, 200))
+	q.flush()
+
+	batch := <-q.events
+	if batch.Seek != 1 || len(batch.Events) != 1 || batch.Events[0].val.Bytes != 200 {
+		t.Errorf("batch after reset = %+v, want only the new event, stamped with seek 1", batch)
+	}
+	select {
+	case batch := <-q.events:
+		t.Errorf("batch from before the reset still queued: %+v", batch)
+	default:
+	}
+}